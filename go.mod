@@ -8,6 +8,7 @@ require (
 	github.com/mattermost/mattermost-server v0.0.0-20191107143132-540cfb0239df
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/crypto v0.0.0-20191001170739-f9e2070545dc
 )
 
 replace github.com/mattermost/mattermost-server v0.0.0-20191107143132-540cfb0239df => github.com/ilgooz/mattermost-server v1.4.1-0.20191116075143-17a352055207
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// apiPathPrefix is the path prefix of the REST API.
+	apiPathPrefix = "/api/v1/"
+)
+
+// apiPluginStatus is the update status of an installed plugin.
+type apiPluginStatus struct {
+	// PluginID is the id of the plugin.
+	PluginID string `json:"plugin_id"`
+
+	// InstalledVersion is the currently installed version of the plugin.
+	InstalledVersion string `json:"installed_version"`
+
+	// LatestVersion is the latest version of the plugin in the Marketplace.
+	LatestVersion string `json:"latest_version,omitempty"`
+
+	// UpdateAvailable is set when the plugin will be updated to its latest version.
+	UpdateAvailable bool `json:"update_available"`
+
+	// Status is a short description of the status.
+	Status string `json:"status"`
+
+	// Error is the reason why the plugin cannot be updated.
+	Error string `json:"error,omitempty"`
+}
+
+// apiPolicies are the update policies of plugins.
+type apiPolicies struct {
+	// Policy is the update policy of plugins that has no policy set in PluginPolicies.
+	Policy updater.UpdatePolicy `json:"policy"`
+
+	// PluginPolicies keeps update policies per plugin(id).
+	PluginPolicies map[string]updater.UpdatePolicy `json:"plugin_policies"`
+}
+
+// apiError is the response of a failed request.
+type apiError struct {
+	// Error is the reason of the failure.
+	Error string `json:"error"`
+}
+
+// serveAPI serves the REST API under apiPathPrefix to system admins.
+// routes are matched manually to not depend on a router for a few endpoints.
+func (p *Plugin) serveAPI(w http.ResponseWriter, r *http.Request) {
+	// Mattermost sets the user header for the authenticated requests.
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("not authenticated"))
+		return
+	}
+	if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		writeError(w, http.StatusForbidden, errors.New("only system admins can use the API"))
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, apiPathPrefix)
+	switch {
+	case path == "status":
+		if allowMethods(w, r, http.MethodGet) {
+			p.handleStatus(w, r)
+		}
+	case path == "check":
+		if allowMethods(w, r, http.MethodPost) {
+			p.handleCheck(w)
+		}
+	case strings.HasPrefix(path, "update/"):
+		if allowMethods(w, r, http.MethodPost) {
+			p.handleUpdate(w, strings.TrimPrefix(path, "update/"))
+		}
+	case path == "history":
+		if allowMethods(w, r, http.MethodGet) {
+			p.handleHistory(w, r)
+		}
+	case path == "policies":
+		if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+			return
+		}
+		if r.Method == http.MethodPut {
+			p.handlePutPolicies(w, r)
+			return
+		}
+		writeJSONStatus(w, http.StatusOK, p.policies())
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// allowMethods checks if the method of r is one of methods. otherwise it responds with
+// 405 Method Not Allowed.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+// handleStatus responds with the installed plugins against their latest versions in the Marketplace.
+// listing the plugins is not retried anymore once the request is cancelled.
+func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	statuses, err := p.updater.Status(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	response := make([]apiPluginStatus, len(statuses))
+	for i, status := range statuses {
+		response[i] = apiPluginStatus{
+			PluginID:         status.PluginID,
+			InstalledVersion: status.InstalledVersion,
+			LatestVersion:    status.LatestVersion,
+			UpdateAvailable:  status.Err == nil,
+			Status:           formatStatus(status),
+		}
+		if status.Err != nil {
+			response[i].Error = status.Err.Error()
+		}
+	}
+	writeJSONStatus(w, http.StatusOK, response)
+}
+
+// handleCheck starts checking for new versions.
+func (p *Plugin) handleCheck(w http.ResponseWriter) {
+	if err := p.updater.Check(); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleUpdate starts updating plugin with id.
+func (p *Plugin) handleUpdate(w http.ResponseWriter, id string) {
+	if id == "" {
+		writeError(w, http.StatusNotFound, errors.New("plugin id is missing"))
+		return
+	}
+	if err := p.updater.UpdatePlugin(id); err != nil {
+		writeError(w, updateErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// updateErrorStatus returns the HTTP status code of err returned by a manual update.
+func updateErrorStatus(err error) int {
+	switch err {
+	case updater.ErrPluginNotInstalled:
+		return http.StatusNotFound
+	case updater.ErrUpdateInProgress, updater.ErrDryRun, updater.ErrNoNewerVersion:
+		return http.StatusConflict
+	case updater.ErrStopped:
+		return http.StatusServiceUnavailable
+	}
+	switch errors.Cause(err).(type) {
+	case *marketplace.NotFoundError:
+		return http.StatusNotFound
+	case *updater.VersionError, *updater.ServerVersionError, *updater.ReleaseChannelError:
+		return http.StatusConflict
+	case *marketplace.APIError:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// handleHistory responds with the update history filtered and paginated by the plugin_id, page
+// and per_page query parameters.
+func (p *Plugin) handleHistory(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := updater.HistoryQuery{PluginID: values.Get("plugin_id")}
+	for name, n := range map[string]*int{"page": &query.Page, "per_page": &query.PerPage} {
+		s := values.Get(name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			writeError(w, http.StatusBadRequest, errors.Errorf("invalid %s %q, it should be a non-negative number", name, s))
+			return
+		}
+		*n = v
+	}
+	records, err := p.updater.History(query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if records == nil {
+		records = []updater.HistoryRecord{}
+	}
+	writeJSONStatus(w, http.StatusOK, records)
+}
+
+// policies returns the current update policies.
+func (p *Plugin) policies() apiPolicies {
+	policy, pluginPolicies := p.updater.UpdatePolicies()
+	return apiPolicies{Policy: policy, PluginPolicies: pluginPolicies}
+}
+
+// handlePutPolicies replaces the update policies, the policy is kept when it is omitted. policies
+// are saved to the plugin config so they're kept after restarts and shared by all nodes.
+func (p *Plugin) handlePutPolicies(w http.ResponseWriter, r *http.Request) {
+	var request apiPolicies
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid policies"))
+		return
+	}
+	// keep the current policy when it's omitted so only the per plugin policies can be replaced.
+	policy, _ := p.updater.UpdatePolicies()
+	if request.Policy != "" {
+		var err error
+		if policy, err = updater.ParseUpdatePolicy(string(request.Policy)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	formatted := updater.FormatPluginUpdatePolicies(request.PluginPolicies)
+	pluginPolicies, err := updater.ParsePluginUpdatePolicies(formatted)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	conf := p.API.GetPluginConfig()
+	if conf == nil {
+		conf = make(map[string]interface{})
+	}
+	setPluginConfig(conf, "UpdatePolicy", string(policy))
+	setPluginConfig(conf, "PluginUpdatePolicies", formatted)
+	if aerr := p.API.SavePluginConfig(conf); aerr != nil {
+		writeError(w, http.StatusInternalServerError, errors.Wrap(aerr, "cannot save the policies"))
+		return
+	}
+	// apply the policies right away without waiting for the configuration change.
+	p.updater.UpdateConfig(updater.UpdatePolicyOption(policy, pluginPolicies))
+	writeJSONStatus(w, http.StatusOK, p.policies())
+}
+
+// setPluginConfig sets key of conf to value. keys are matched case insensitively since Mattermost
+// keeps them in lowercase.
+func setPluginConfig(conf map[string]interface{}, key string, value interface{}) {
+	for k := range conf {
+		if strings.EqualFold(k, key) {
+			delete(conf, k)
+		}
+	}
+	conf[strings.ToLower(key)] = value
+}
+
+// writeError writes err to w with status code.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSONStatus(w, code, apiError{Error: err.Error()})
+}
+
+// writeJSONStatus writes v to w as JSON with status code.
+func writeJSONStatus(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
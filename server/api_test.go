@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIAuth(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	p := newTestPlugin(apiMock, nil)
+
+	w := serve(p, http.MethodGet, "/api/v1/status", "", nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "not authenticated", decodeAPIError(t, w))
+
+	w = serve(p, http.MethodGet, "/api/v1/status", "user", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "only system admins can use the API", decodeAPIError(t, w))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestAPIMethods(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	p := newTestPlugin(apiMock, nil)
+
+	tests := []struct {
+		method, path, allow string
+	}{
+		{http.MethodPost, "/api/v1/status", "GET"},
+		{http.MethodGet, "/api/v1/check", "POST"},
+		{http.MethodGet, "/api/v1/update/topdf", "POST"},
+		{http.MethodDelete, "/api/v1/history", "GET"},
+		{http.MethodPost, "/api/v1/policies", "GET, PUT"},
+	}
+	for _, tt := range tests {
+		w := serve(p, tt.method, tt.path, "admin", nil)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code, "%s %s", tt.method, tt.path)
+		require.Equal(t, tt.allow, w.Header().Get("Allow"), "%s %s", tt.method, tt.path)
+	}
+
+	w := serve(p, http.MethodGet, "/api/v1/plugins", "admin", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIStatus(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	mockKV(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Once().Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+	p := newTestPlugin(apiMock, marketplaceMock)
+
+	w := serve(p, http.MethodGet, "/api/v1/status", "admin", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var statuses []apiPluginStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	require.Len(t, statuses, 2)
+	require.Equal(t, apiPluginStatus{
+		PluginID:         "topdf",
+		InstalledVersion: "1.2.1",
+		LatestVersion:    "1.3.0",
+		UpdateAvailable:  true,
+		Status:           statuses[0].Status,
+	}, statuses[0])
+	require.Equal(t, "zoom", statuses[1].PluginID)
+	require.False(t, statuses[1].UpdateAvailable)
+	require.Equal(t, updater.ErrNoNewerVersion.Error(), statuses[1].Error)
+
+	// a Marketplace that rejects the requests is a bad gateway.
+	marketplaceMock.On("ListPlugins").Return(nil, &marketplace.APIError{StatusCode: http.StatusUnauthorized, Endpoint: "/api/v1/plugins"})
+	w = serve(p, http.MethodGet, "/api/v1/status", "admin", nil)
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	require.Contains(t, decodeAPIError(t, w), "cannot get a list of plugins from Marketplace")
+
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestAPICheck(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	mockKV(apiMock)
+	checked := make(chan struct{})
+	apiMock.On("GetPlugins").Once().Return(nil, nil).Run(func(mock.Arguments) {
+		close(checked)
+	})
+	// dry-run mode keeps the check from installing anything.
+	p := newTestPlugin(apiMock, nil, updater.DryRunOption(true))
+
+	w := serve(p, http.MethodPost, "/api/v1/check", "admin", nil)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates should be checked")
+	}
+}
+
+func TestAPIUpdate(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	mockKV(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "zoom", Version: "1.0.0"}}, nil)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+	p := newTestPlugin(apiMock, marketplaceMock)
+
+	tests := []struct {
+		path string
+		code int
+		err  string
+	}{
+		{"/api/v1/update/", http.StatusNotFound, "plugin id is missing"},
+		{"/api/v1/update/antivirus", http.StatusNotFound, updater.ErrPluginNotInstalled.Error()},
+		{"/api/v1/update/zoom", http.StatusConflict, updater.ErrNoNewerVersion.Error()},
+	}
+	for _, tt := range tests {
+		w := serve(p, http.MethodPost, tt.path, "admin", nil)
+		require.Equal(t, tt.code, w.Code, tt.path)
+		require.Equal(t, tt.err, decodeAPIError(t, w), tt.path)
+	}
+
+	p.updater.UpdateConfig(updater.DryRunOption(true))
+	w := serve(p, http.MethodPost, "/api/v1/update/zoom", "admin", nil)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, updater.ErrDryRun.Error(), decodeAPIError(t, w))
+}
+
+func TestAPIHistory(t *testing.T) {
+	var records []updater.HistoryRecord
+	for _, id := range []string{"topdf", "jira", "topdf", "topdf"} {
+		records = append(records, updater.HistoryRecord{PluginID: id, Outcome: updater.OutcomeUpdated})
+	}
+	for i := range records {
+		records[i].ToVersion = fmt.Sprintf("%d.0.0", i+1)
+	}
+	data, err := json.Marshal(records)
+	require.NoError(t, err)
+
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	apiMock.On("KVGet", "marketplace-addon:history").Return(data, nil)
+	p := newTestPlugin(apiMock, nil)
+
+	tests := []struct {
+		query    string
+		versions []string
+	}{
+		{"", []string{"4.0.0", "3.0.0", "2.0.0", "1.0.0"}},
+		{"?plugin_id=topdf", []string{"4.0.0", "3.0.0", "1.0.0"}},
+		{"?plugin_id=topdf&per_page=2", []string{"4.0.0", "3.0.0"}},
+		{"?plugin_id=topdf&per_page=2&page=1", []string{"1.0.0"}},
+		{"?page=5", []string{}},
+	}
+	for _, tt := range tests {
+		w := serve(p, http.MethodGet, "/api/v1/history"+tt.query, "admin", nil)
+		require.Equal(t, http.StatusOK, w.Code, tt.query)
+		var received []updater.HistoryRecord
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&received))
+		versions := []string{}
+		for _, record := range received {
+			versions = append(versions, record.ToVersion)
+		}
+		require.Equal(t, tt.versions, versions, tt.query)
+	}
+
+	for _, query := range []string{"?page=-1", "?per_page=ten"} {
+		w := serve(p, http.MethodGet, "/api/v1/history"+query, "admin", nil)
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestAPIPolicies(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	apiMock.On("GetPluginConfig").Return(map[string]interface{}{
+		"UpdatePolicy":            "major",
+		"notificationchannelname": "updates",
+	})
+	apiMock.On("SavePluginConfig", map[string]interface{}{
+		"updatepolicy":            "minor",
+		"pluginupdatepolicies":    "jira:patch",
+		"notificationchannelname": "updates",
+	}).Once().Return(nil)
+	apiMock.On("SavePluginConfig", map[string]interface{}{
+		"updatepolicy":            "minor",
+		"pluginupdatepolicies":    "jira:minor",
+		"notificationchannelname": "updates",
+	}).Once().Return(nil)
+	p := newTestPlugin(apiMock, nil, updater.UpdatePolicyOption(updater.PolicyMajor, nil))
+
+	w := serve(p, http.MethodGet, "/api/v1/policies", "admin", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var policies apiPolicies
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&policies))
+	require.Equal(t, updater.PolicyMajor, policies.Policy)
+
+	w = serve(p, http.MethodPut, "/api/v1/policies", "admin",
+		strings.NewReader(`{"policy": "minor", "plugin_policies": {"jira": "patch"}}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	policies = apiPolicies{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&policies))
+	require.Equal(t, apiPolicies{
+		Policy:         updater.PolicyMinor,
+		PluginPolicies: map[string]updater.UpdatePolicy{"jira": updater.PolicyPatch},
+	}, policies)
+
+	// the policy is kept when only the per plugin policies are replaced.
+	w = serve(p, http.MethodPut, "/api/v1/policies", "admin",
+		strings.NewReader(`{"plugin_policies": {"jira": "minor"}}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	policies = apiPolicies{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&policies))
+	require.Equal(t, apiPolicies{
+		Policy:         updater.PolicyMinor,
+		PluginPolicies: map[string]updater.UpdatePolicy{"jira": updater.PolicyMinor},
+	}, policies)
+
+	for _, body := range []string{
+		`{"policy": `,
+		`{"policy": "latest"}`,
+		`{"policy": "minor", "plugin_policies": {"jira": "latest"}}`,
+	} {
+		w := serve(p, http.MethodPut, "/api/v1/policies", "admin", strings.NewReader(body))
+		require.Equal(t, http.StatusBadRequest, w.Code, body)
+		require.NotEmpty(t, decodeAPIError(t, w), body)
+	}
+	// invalid policies are not applied.
+	policy, _ := p.updater.UpdatePolicies()
+	require.Equal(t, updater.PolicyMinor, policy)
+
+	apiMock.AssertExpectations(t)
+}
+
+// newTestPlugin creates a Plugin with apiMock and an updater that lists plugins from
+// marketplaceMock with options.
+func newTestPlugin(apiMock *apimock.API, marketplaceMock *updatermock.Marketplace, options ...updater.Option) *Plugin {
+	p := &Plugin{}
+	p.API = apiMock
+	var m updater.Marketplace
+	if marketplaceMock != nil {
+		m = marketplaceMock
+	}
+	p.updater = updater.New(apiMock, m, dlocktest.NewStore(), options...)
+	return p
+}
+
+// mockAdmin mocks the permissions of a system admin with the admin user id.
+func mockAdmin(apiMock *apimock.API) {
+	apiMock.On("HasPermissionTo", "admin", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+}
+
+// mockKV mocks an empty KV store and the logs.
+func mockKV(apiMock *apimock.API) {
+	apiMock.On("KVGet", mock.Anything).Maybe().Return(nil, nil)
+	apiMock.On("LogInfo", mock.Anything).Maybe()
+}
+
+// serve sends a request with method and body to path as the user with userID.
+func serve(p *Plugin, method, path, userID string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, body)
+	if userID != "" {
+		r.Header.Set("Mattermost-User-Id", userID)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, r)
+	return w
+}
+
+// decodeAPIError decodes the error message from the response of a failed request.
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) string {
+	var response apiError
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	return response.Error
+}
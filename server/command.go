@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// commandTrigger is the trigger of the slash command.
+	commandTrigger = "marketplace"
+
+	// statusTimeout is the max time to wait for the status of plugins, it is shorter than the
+	// time that Mattermost waits for a response to the slash command.
+	statusTimeout = 20 * time.Second
+
+	// commandHelp is the help text of the slash command.
+	commandHelp = "###### Marketplace Addon\n" +
+		"- `/marketplace status` - Show installed plugins against their latest versions in the Marketplace.\n" +
+		"- `/marketplace check` - Check for new versions and update plugins now.\n" +
+		"- `/marketplace update <plugin-id>` - Update a plugin to its latest version now.\n" +
+		"- `/marketplace skip <plugin-id>` - Never update a plugin until it is unheld.\n" +
+		"- `/marketplace hold <plugin-id>[@<version-range>] [until <yyyy-mm-dd>][: <reason>]` - Hold updates of a plugin or pin it to a version range, e.g. `jira@1.4.x until 2026-12-01: waiting for vendor fix`.\n" +
+		"- `/marketplace unhold <plugin-id>` - Remove the hold of a plugin.\n" +
+		"- `/marketplace holds` - List held plugins.\n"
+)
+
+// registerCommand registers the slash command.
+func (p *Plugin) registerCommand() error {
+	return p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      botDisplayName,
+		Description:      "Manage automatic plugin updates.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: status, check, update, skip, hold, unhold, holds",
+		AutoCompleteHint: "[command]",
+	})
+}
+
+// ExecuteCommand executes the slash command.
+// only system admins are allowed to use it.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return respond("Only system admins can use this command."), nil
+	}
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return respond(commandHelp), nil
+	}
+	command, params := fields[1], fields[2:]
+	switch command {
+	case "status":
+		return respond(p.executeStatus()), nil
+	case "check":
+		return respond(p.executeCheck()), nil
+	case "update":
+		if len(params) != 1 {
+			return respond("Usage: `/marketplace update <plugin-id>`"), nil
+		}
+		return respond(p.executeUpdate(params[0])), nil
+	case "skip":
+		if len(params) != 1 {
+			return respond("Usage: `/marketplace skip <plugin-id>`"), nil
+		}
+		return respond(p.executeSkip(params[0])), nil
+	case "hold":
+		if len(params) == 0 {
+			return respond("Usage: `/marketplace hold <plugin-id>[@<version-range>] [until <yyyy-mm-dd>][: <reason>]`"), nil
+		}
+		return respond(p.executeHold(strings.Join(params, " "))), nil
+	case "unhold":
+		if len(params) != 1 {
+			return respond("Usage: `/marketplace unhold <plugin-id>`"), nil
+		}
+		return respond(p.executeUnhold(params[0])), nil
+	case "holds":
+		return respond(p.executeHolds()), nil
+	default:
+		return respond(commandHelp), nil
+	}
+}
+
+// executeStatus shows installed plugins against their latest versions in the Marketplace.
+func (p *Plugin) executeStatus() string {
+	ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+	defer cancel()
+	statuses, err := p.updater.Status(ctx)
+	if err != nil {
+		return fmt.Sprintf("Cannot get the status: %s", err)
+	}
+	if len(statuses) == 0 {
+		return "There are no installed plugins."
+	}
+	message := "| Plugin | Installed | Latest | Status |\n|:--|:--|:--|:--|\n"
+	for _, status := range statuses {
+		message += fmt.Sprintf("| %s | %s | %s | %s |\n", status.PluginID, status.InstalledVersion,
+			status.LatestVersion, formatStatus(status))
+	}
+	if cached, ok := p.updater.Marketplace().(interface{ CacheStats() marketplace.CacheStats }); ok {
+		stats := cached.CacheStats()
+		message += fmt.Sprintf("\nMarketplace cache: %d hits, %d revalidated pages, %d downloaded pages, %d stale hits.\n",
+			stats.Hits, stats.Revalidations, stats.Misses, stats.StaleHits)
+	}
+	if p.notifications != nil {
+		if dropped := p.notifications.Dropped(); dropped > 0 {
+			message += fmt.Sprintf("\n%d notifications are dropped since the notifier could not keep up.\n", dropped)
+		}
+	}
+	if p.events != nil {
+		if dropped := p.events.Dropped(); dropped > 0 {
+			message += fmt.Sprintf("\n%d events are dropped since they could not be logged in time.\n", dropped)
+		}
+	}
+	return message
+}
+
+// executeCheck starts checking for new versions.
+func (p *Plugin) executeCheck() string {
+	if err := p.updater.Check(); err != nil {
+		return fmt.Sprintf("Cannot check for new versions: %s", err)
+	}
+	return "Checking for new versions, updates will be notified."
+}
+
+// executeUpdate starts updating plugin with id.
+func (p *Plugin) executeUpdate(id string) string {
+	if err := p.updater.UpdatePlugin(id); err != nil {
+		return fmt.Sprintf("Cannot update `%s`: %s", id, err)
+	}
+	return fmt.Sprintf("Updating `%s`, the result will be notified.", id)
+}
+
+// executeSkip holds all updates of plugin with id.
+func (p *Plugin) executeSkip(id string) string {
+	if err := p.updater.SkipPlugin(id); err != nil {
+		return fmt.Sprintf("Cannot skip `%s`: %s", id, err)
+	}
+	return fmt.Sprintf("`%s` will not be updated until it is unheld.", id)
+}
+
+// executeHold holds updates of a plugin by the hold in s.
+func (p *Plugin) executeHold(s string) string {
+	hold, err := updater.ParseHold(s)
+	if err != nil {
+		return fmt.Sprintf("Cannot hold: %s", err)
+	}
+	if err := p.updater.Hold(hold); err != nil {
+		return fmt.Sprintf("Cannot hold `%s`: %s", hold.PluginID, err)
+	}
+	return fmt.Sprintf("Held `%s`.", hold)
+}
+
+// executeUnhold removes the hold of plugin with id.
+func (p *Plugin) executeUnhold(id string) string {
+	if err := p.updater.Unhold(id); err != nil {
+		return fmt.Sprintf("Cannot unhold `%s`: %s", id, err)
+	}
+	return fmt.Sprintf("`%s` is not held anymore.", id)
+}
+
+// executeHolds lists the held plugins.
+func (p *Plugin) executeHolds() string {
+	holds, err := p.updater.Holds()
+	if err != nil {
+		return fmt.Sprintf("Cannot get the holds: %s", err)
+	}
+	if len(holds) == 0 {
+		return "There are no held plugins."
+	}
+	message := "| Plugin | Hold |\n|:--|:--|\n"
+	for _, hold := range holds {
+		message += fmt.Sprintf("| %s | %s |\n", hold.PluginID, capitalize(hold.Describe()))
+	}
+	return message
+}
+
+// formatStatus creates a short description of the update status of a plugin.
+func formatStatus(status updater.PluginStatus) string {
+	switch e := status.Err.(type) {
+	case nil:
+		return "Update available"
+	case *marketplace.NotFoundError:
+		return "Not in the Marketplace"
+	case *updater.UpdatePolicyError:
+		return fmt.Sprintf("Blocked by the %s update policy", e.Policy)
+	case *updater.ServerVersionError:
+		return fmt.Sprintf("Requires Mattermost Server %s", e.RequiredServerVersion)
+	case *updater.ReleaseChannelError:
+		return fmt.Sprintf("Not released in the %s channel", e.Channel)
+	case *updater.HoldError:
+		return capitalize(e.Hold.Describe())
+	case *updater.SoakError:
+		return fmt.Sprintf("Pending soak until %s", e.Until.UTC().Format("2006-01-02 15:04 MST"))
+	case *updater.RolledBackVersionError:
+		return "Rolled back after failing the health checks"
+	}
+	switch status.Err {
+	case updater.ErrNoNewerVersion:
+		return "Up to date"
+	case updater.ErrPluginInSkipList:
+		return "Skipped"
+	}
+	return status.Err.Error()
+}
+
+// capitalize capitalizes the first letter of s.
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// respond creates an ephemeral response with message.
+func respond(message string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         message,
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCommandAuth(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	p := newTestPlugin(apiMock, nil)
+
+	require.Equal(t, "Only system admins can use this command.", execute(t, p, "user", "/marketplace status"))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestExecuteCommandUsage(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	p := newTestPlugin(apiMock, nil)
+
+	tests := []struct {
+		command string
+		text    string
+	}{
+		{"/marketplace", commandHelp},
+		{"/marketplace help", commandHelp},
+		{"/marketplace update", "Usage: `/marketplace update <plugin-id>`"},
+		{"/marketplace skip jira zoom", "Usage: `/marketplace skip <plugin-id>`"},
+		{"/marketplace hold", "Usage: `/marketplace hold <plugin-id>[@<version-range>] [until <yyyy-mm-dd>][: <reason>]`"},
+		{"/marketplace unhold", "Usage: `/marketplace unhold <plugin-id>`"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.text, execute(t, p, "admin", tt.command), tt.command)
+	}
+}
+
+func TestExecuteCommandStatus(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	mockKV(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+	p := newTestPlugin(apiMock, marketplaceMock)
+
+	require.Equal(t, "| Plugin | Installed | Latest | Status |\n|:--|:--|:--|:--|\n"+
+		"| topdf | 1.2.1 | 1.3.0 | Update available |\n"+
+		"| zoom | 1.0.0 | 1.0.0 | Up to date |\n", execute(t, p, "admin", "/marketplace status"))
+}
+
+func TestExecuteCommandUpdate(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	mockKV(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "zoom", Version: "1.0.0"}}, nil)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+	p := newTestPlugin(apiMock, marketplaceMock)
+
+	require.Equal(t, "Cannot update `zoom`: "+updater.ErrNoNewerVersion.Error(),
+		execute(t, p, "admin", "/marketplace update zoom"))
+	require.Equal(t, "Cannot update `antivirus`: "+updater.ErrPluginNotInstalled.Error(),
+		execute(t, p, "admin", "/marketplace update antivirus"))
+}
+
+func TestExecuteCommandHolds(t *testing.T) {
+	var holdsData []byte
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	apiMock.On("KVGet", "marketplace-addon:holds").Return(func(string) []byte { return holdsData }, nil)
+	apiMock.On("KVCompareAndSet", "marketplace-addon:holds", mock.Anything, mock.Anything).Return(
+		func(key string, oldData, newData []byte) bool {
+			if string(oldData) != string(holdsData) {
+				return false
+			}
+			holdsData = newData
+			return true
+		}, nil)
+	p := newTestPlugin(apiMock, nil)
+
+	require.Equal(t, "There are no held plugins.", execute(t, p, "admin", "/marketplace holds"))
+	require.Equal(t, "Held `jira@1.4.x until 2099-12-01: waiting for vendor fix`.",
+		execute(t, p, "admin", "/marketplace hold jira@1.4.x until 2099-12-01: waiting for vendor fix"))
+	require.Equal(t, "`github` will not be updated until it is unheld.", execute(t, p, "admin", "/marketplace skip github"))
+	require.Equal(t, "| Plugin | Hold |\n|:--|:--|\n"+
+		"| github | Held: skipped |\n"+
+		"| jira | Pinned to \"1.4.x\" until 2099-12-01: waiting for vendor fix |\n",
+		execute(t, p, "admin", "/marketplace holds"))
+
+	require.Equal(t, "`github` is not held anymore.", execute(t, p, "admin", "/marketplace unhold github"))
+	require.Equal(t, "Cannot unhold `github`: "+updater.ErrHoldNotFound.Error(),
+		execute(t, p, "admin", "/marketplace unhold github"))
+	require.Contains(t, execute(t, p, "admin", "/marketplace hold jira@latest"), "Cannot hold: ")
+}
+
+func TestFormatStatus(t *testing.T) {
+	until := time.Date(2026, 12, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		err    error
+		status string
+	}{
+		{nil, "Update available"},
+		{updater.ErrNoNewerVersion, "Up to date"},
+		{updater.ErrPluginInSkipList, "Skipped"},
+		{&marketplace.NotFoundError{}, "Not in the Marketplace"},
+		{&updater.UpdatePolicyError{Policy: updater.PolicyMinor}, "Blocked by the minor update policy"},
+		{&updater.HoldError{Hold: updater.Hold{Version: "1.4.x", Reason: "waiting for vendor fix"}},
+			`Pinned to "1.4.x": waiting for vendor fix`},
+		{&updater.SoakError{Until: until}, "Pending soak until 2026-12-01 10:30 UTC"},
+		{&updater.RolledBackVersionError{}, "Rolled back after failing the health checks"},
+		{updater.ErrDryRun, updater.ErrDryRun.Error()},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.status, formatStatus(updater.PluginStatus{Err: tt.err}), "%v", tt.err)
+	}
+}
+
+// execute executes command as the user with userID and returns the text of the response.
+func execute(t *testing.T, p *Plugin, userID, command string) string {
+	response, aerr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: userID, Command: command})
+	require.Nil(t, aerr)
+	require.Equal(t, model.COMMAND_RESPONSE_TYPE_EPHEMERAL, response.ResponseType)
+	return response.Text
+}
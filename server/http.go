@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/notifier"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// approvalPath is the path that handles the buttons of approval requests.
+	approvalPath = "/approval"
+
+	// snoozeDuration is the time to wait before requesting a snoozed approval again.
+	snoozeDuration = 24 * time.Hour
+)
+
+// approvalURL returns the URL of approvalPath that is posted with approval requests.
+func approvalURL() string {
+	return "/plugins/" + manifest.ID + approvalPath
+}
+
+// ServeHTTP serves the buttons of approval requests and the REST API.
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == approvalPath:
+		p.handleApproval(w, r)
+	case strings.HasPrefix(r.URL.Path, apiPathPrefix):
+		p.serveAPI(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleApproval approves, rejects or snoozes an update by the button clicked by a system admin.
+// the approval request is updated with the decision.
+func (p *Plugin) handleApproval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// Mattermost sets the user header for the authenticated requests.
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		http.Error(w, "invalid post action request", http.StatusBadRequest)
+		return
+	}
+	response := &model.PostActionIntegrationResponse{}
+	if !p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		response.EphemeralText = "Only system admins can decide on updates."
+		writeJSON(w, response)
+		return
+	}
+	decision, _ := request.Context[notifier.ApprovalContextDecision].(string)
+	id, _ := request.Context[notifier.ApprovalContextPluginID].(string)
+	version, _ := request.Context[notifier.ApprovalContextVersion].(string)
+	var err error
+	var result string
+	switch decision {
+	case notifier.DecisionApprove:
+		err = p.updater.Approve(id, version, userID)
+		result = ":white_check_mark: **Approved** by @%s, the update will be installed on the next check."
+	case notifier.DecisionReject:
+		err = p.updater.Reject(id, version, userID)
+		result = ":no_entry_sign: **Rejected** by @%s."
+	case notifier.DecisionSnooze:
+		err = p.updater.Snooze(id, version, userID, snoozeDuration)
+		result = fmt.Sprintf(":zzz: **Snoozed** by @%%s, the approval will be requested again in %s.", snoozeDuration)
+	default:
+		err = fmt.Errorf("unknown decision %q", decision)
+	}
+	if err != nil {
+		response.EphemeralText = fmt.Sprintf("Cannot %s updating `%s` to `%s`: %s", decision, id, version, err)
+		writeJSON(w, response)
+		return
+	}
+	if decision == notifier.DecisionApprove {
+		if err := p.updater.Check(); err != nil && err != updater.ErrStopped {
+			p.logError(errors.Wrap(err, "cannot check for the approved update"))
+		}
+	}
+	response.Update = p.decidedPost(request.PostId, userID, result)
+	writeJSON(w, response)
+}
+
+// decidedPost creates an update of the approval request post with postID that replaces its
+// buttons with the result of the decision made by user with userID.
+func (p *Plugin) decidedPost(postID, userID, result string) *model.Post {
+	username := userID
+	if user, aerr := p.API.GetUser(userID); aerr == nil {
+		username = user.Username
+	}
+	message := ""
+	if post, aerr := p.API.GetPost(postID); aerr == nil {
+		message = post.Message + "\n"
+	}
+	// an empty props removes the buttons.
+	return &model.Post{
+		Message: message + fmt.Sprintf(result, username),
+		Props:   model.StringInterface{},
+	}
+}
+
+// writeJSON writes v to w as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/notifier"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleApproval(t *testing.T) {
+	var approvalData []byte
+	apiMock := &apimock.API{}
+	mockAdmin(apiMock)
+	apiMock.On("HasPermissionTo", "user", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	apiMock.On("KVGet", "marketplace-addon:approval:topdf").Return(func(string) []byte { return approvalData }, nil)
+	apiMock.On("KVCompareAndSet", "marketplace-addon:approval:topdf", mock.Anything, mock.Anything).Return(
+		func(key string, oldData, newData []byte) bool {
+			if string(oldData) != string(approvalData) {
+				return false
+			}
+			approvalData = newData
+			return true
+		}, nil)
+	apiMock.On("GetUser", "admin").Return(&model.User{Id: "admin", Username: "alice"}, nil)
+	apiMock.On("GetPost", "post").Return(&model.Post{Id: "post", Message: "Update available"}, nil)
+	mockKV(apiMock)
+	checked := make(chan struct{})
+	apiMock.On("GetPlugins").Once().Return(nil, nil).Run(func(mock.Arguments) {
+		close(checked)
+	})
+	// dry-run mode keeps the check of an approved update from installing anything.
+	p := newTestPlugin(apiMock, nil, updater.DryRunOption(true))
+
+	// requestApproval resets the approval of topdf to a pending one.
+	requestApproval := func() {
+		data, err := json.Marshal(updater.Approval{PluginID: "topdf", Version: "1.3.0", State: updater.ApprovalPending})
+		require.NoError(t, err)
+		approvalData = data
+	}
+	// decide sends decision on updating topdf to version as the user with userID.
+	decide := func(userID, decision, version string) *model.PostActionIntegrationResponse {
+		request := &model.PostActionIntegrationRequest{
+			UserId: userID,
+			PostId: "post",
+			Context: map[string]interface{}{
+				notifier.ApprovalContextDecision: decision,
+				notifier.ApprovalContextPluginID: "topdf",
+				notifier.ApprovalContextVersion:  version,
+			},
+		}
+		w := serve(p, http.MethodPost, approvalPath, userID, bytes.NewReader(request.ToJson()))
+		require.Equal(t, http.StatusOK, w.Code)
+		var response model.PostActionIntegrationResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return &response
+	}
+
+	t.Run("invalid requests", func(t *testing.T) {
+		w := serve(p, http.MethodGet, approvalPath, "admin", nil)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		w = serve(p, http.MethodPost, approvalPath, "", strings.NewReader("{}"))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		w = serve(p, http.MethodPost, approvalPath, "admin", strings.NewReader("not json"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("non-admin", func(t *testing.T) {
+		requestApproval()
+		response := decide("user", notifier.DecisionApprove, "1.3.0")
+		require.Equal(t, "Only system admins can decide on updates.", response.EphemeralText)
+		require.Nil(t, response.Update)
+		approval, err := p.updater.Approval("topdf")
+		require.NoError(t, err)
+		require.Equal(t, updater.ApprovalPending, approval.State)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		requestApproval()
+		response := decide("admin", notifier.DecisionApprove, "1.3.0")
+		require.Empty(t, response.EphemeralText)
+		require.Equal(t, "Update available\n:white_check_mark: **Approved** by @alice, the update will be installed on the next check.",
+			response.Update.Message)
+		require.Empty(t, response.Update.Props)
+		approval, err := p.updater.Approval("topdf")
+		require.NoError(t, err)
+		require.Equal(t, updater.ApprovalApproved, approval.State)
+		require.Equal(t, "admin", approval.DecidedBy)
+		// the approved update is checked right away.
+		select {
+		case <-checked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("updates should be checked")
+		}
+
+		// a decided approval cannot be decided again.
+		response = decide("admin", notifier.DecisionReject, "1.3.0")
+		require.Nil(t, response.Update)
+		require.Equal(t, "Cannot reject updating `topdf` to `1.3.0`: "+
+			(&updater.ApprovalDecidedError{Approval: *approval}).Error(), response.EphemeralText)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		requestApproval()
+		response := decide("admin", notifier.DecisionReject, "1.3.0")
+		require.Equal(t, "Update available\n:no_entry_sign: **Rejected** by @alice.", response.Update.Message)
+		approval, err := p.updater.Approval("topdf")
+		require.NoError(t, err)
+		require.Equal(t, updater.ApprovalRejected, approval.State)
+	})
+
+	t.Run("snooze", func(t *testing.T) {
+		requestApproval()
+		response := decide("admin", notifier.DecisionSnooze, "1.3.0")
+		require.Equal(t, "Update available\n:zzz: **Snoozed** by @alice, the approval will be requested again in 24h0m0s.",
+			response.Update.Message)
+		approval, err := p.updater.Approval("topdf")
+		require.NoError(t, err)
+		require.Equal(t, updater.ApprovalSnoozed, approval.State)
+		require.WithinDuration(t, time.Now().Add(snoozeDuration), approval.SnoozedUntil, time.Minute)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		requestApproval()
+		response := decide("admin", notifier.DecisionApprove, "1.2.5")
+		require.Nil(t, response.Update)
+		require.Equal(t, "Cannot approve updating `topdf` to `1.2.5`: "+updater.ErrApprovalExpired.Error(),
+			response.EphemeralText)
+	})
+
+	t.Run("unknown decision", func(t *testing.T) {
+		requestApproval()
+		response := decide("admin", "postpone", "1.3.0")
+		require.Nil(t, response.Update)
+		require.Equal(t, "Cannot postpone updating `topdf` to `1.3.0`: unknown decision \"postpone\"",
+			response.EphemeralText)
+	})
+}
@@ -0,0 +1,95 @@
+package marketplace
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats keeps metrics of a Marketplace's listing cache.
+type CacheStats struct {
+	// Hits is the number of listings served from the cache without requesting the Marketplace.
+	Hits int64
+
+	// Revalidations is the number of pages that the Marketplace reported as not modified.
+	Revalidations int64
+
+	// Misses is the number of pages downloaded from the Marketplace.
+	Misses int64
+
+	// StaleHits is the number of listings served from the cache because the Marketplace failed.
+	StaleHits int64
+}
+
+// Add sums s and other.
+func (s CacheStats) Add(other CacheStats) CacheStats {
+	return CacheStats{
+		Hits:          s.Hits + other.Hits,
+		Revalidations: s.Revalidations + other.Revalidations,
+		Misses:        s.Misses + other.Misses,
+		StaleHits:     s.StaleHits + other.StaleHits,
+	}
+}
+
+// cachedPage is a page of plugins with its validators.
+type cachedPage struct {
+	// etag and lastModified are sent back with conditional requests.
+	etag, lastModified string
+
+	plugins Plugins
+}
+
+// cache keeps the last listing of a Marketplace.
+type cache struct {
+	// stats are updated atomically to be read while listing.
+	// it's the first field to keep the 64-bit counters aligned on 32-bit platforms.
+	stats CacheStats
+
+	// ttl is how long a listing is served without requesting the Marketplace.
+	ttl time.Duration
+
+	// staleIfError is how long a listing is served when the Marketplace fails.
+	staleIfError time.Duration
+
+	// pages of the last listing by their numbers.
+	pages map[int]*cachedPage
+
+	// plugins of the last listing and the time that they're listed at.
+	plugins   Plugins
+	updatedAt time.Time
+
+	// mu serializes listings so concurrent listings share the cache.
+	mu sync.Mutex
+}
+
+// fresh checks if the last listing can be served at now without requesting the Marketplace.
+func (c *cache) fresh(now time.Time) bool {
+	return !c.updatedAt.IsZero() && now.Sub(c.updatedAt) < c.ttl
+}
+
+// usable checks if the last listing can be served at now when the Marketplace fails.
+func (c *cache) usable(now time.Time) bool {
+	return !c.updatedAt.IsZero() && now.Sub(c.updatedAt) < c.staleIfError
+}
+
+// update saves a new listing made at now.
+func (c *cache) update(pages map[int]*cachedPage, plugins Plugins, now time.Time) {
+	c.pages = pages
+	c.plugins = plugins
+	c.updatedAt = now
+}
+
+// snapshot returns the current stats.
+func (c *cache) snapshot() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.stats.Hits),
+		Revalidations: atomic.LoadInt64(&c.stats.Revalidations),
+		Misses:        atomic.LoadInt64(&c.stats.Misses),
+		StaleHits:     atomic.LoadInt64(&c.stats.StaleHits),
+	}
+}
+
+// page returns the page with number from the last listing.
+func (c *cache) page(number int) *cachedPage {
+	return c.pages[number]
+}
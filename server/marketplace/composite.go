@@ -0,0 +1,147 @@
+package marketplace
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
+	"github.com/pkg/errors"
+)
+
+// Lister lists plugins of a Marketplace.
+type Lister interface {
+	ListPlugins() (Plugins, error)
+}
+
+// Source is a named Marketplace that is aggregated by a Composite.
+type Source struct {
+	// Name of the source used to pin plugins to it.
+	Name string
+
+	// Lister used to list plugins of the source.
+	Lister Lister
+}
+
+// Composite is a Marketplace that aggregates multiple sources.
+// when the same plugin is listed by multiple sources, the one from the source with the highest
+// priority is used unless the plugin is pinned to a source.
+type Composite struct {
+	// sources ordered from the highest priority to the lowest.
+	sources []Source
+
+	// pins maps plugin ids to the names of the only sources that they can be listed from.
+	pins map[string]string
+}
+
+// NewComposite creates a new Composite with sources ordered from the highest priority to the lowest
+// and pins that maps plugin ids to source names.
+func NewComposite(sources []Source, pins map[string]string) (*Composite, error) {
+	names := make(map[string]bool)
+	for _, source := range sources {
+		if names[source.Name] {
+			return nil, fmt.Errorf("duplicate Marketplace source %q", source.Name)
+		}
+		names[source.Name] = true
+	}
+	for id, name := range pins {
+		if !names[name] {
+			return nil, fmt.Errorf("plugin %q is pinned to unknown Marketplace source %q", id, name)
+		}
+	}
+	return &Composite{sources: sources, pins: pins}, nil
+}
+
+// ListPlugins lists plugins from all sources concurrently and merges them by priority.
+// an error is returned when any of the sources cannot be listed, otherwise a plugin from
+// a lower priority source could take the place of the same plugin from the failed source.
+func (c *Composite) ListPlugins() (Plugins, error) {
+	results := make([]Plugins, len(c.sources))
+	errs := make([]error, len(c.sources))
+	var wg sync.WaitGroup
+	for i, source := range c.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			results[i], errs[i] = source.Lister.ListPlugins()
+		}(i, source)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot list plugins of %q Marketplace", c.sources[i].Name)
+		}
+	}
+	var merged Plugins
+	// owners keeps the sources that plugins are listed from. a source can list multiple versions
+	// of the same plugin.
+	owners := make(map[string]int)
+	for i, plugins := range results {
+		for _, plugin := range plugins {
+			id := plugin.Manifest.Id
+			if owner, ok := owners[id]; ok && owner != i {
+				continue
+			}
+			if pin, ok := c.pins[id]; ok && pin != c.sources[i].Name {
+				continue
+			}
+			merged = append(merged, plugin)
+			owners[id] = i
+		}
+	}
+	return merged, nil
+}
+
+// CacheStats returns the sum of the cache metrics of the sources that keep them.
+func (c *Composite) CacheStats() CacheStats {
+	var stats CacheStats
+	for _, source := range c.sources {
+		if cached, ok := source.Lister.(interface{ CacheStats() CacheStats }); ok {
+			stats = stats.Add(cached.CacheStats())
+		}
+	}
+	return stats
+}
+
+// LocalDirs returns the directories of the local sources.
+func (c *Composite) LocalDirs() []string {
+	var dirs []string
+	for _, source := range c.sources {
+		if local, ok := source.Lister.(interface{ LocalDirs() []string }); ok {
+			dirs = append(dirs, local.LocalDirs()...)
+		}
+	}
+	return dirs
+}
+
+// ParseSources parses a comma separated list of Marketplace addresses from s in the form of
+// "internal=https://marketplace.example.com, https://api.integrations.mattermost.com".
+// addresses without a name are named after themselves.
+func ParseSources(s string) (names, addrs []string, err error) {
+	for _, entry := range xstrings.SplitList(s) {
+		name, addr := entry, entry
+		// names cannot contain the characters of a URL's scheme or path.
+		if i := strings.Index(entry, "="); i != -1 && !strings.ContainsAny(entry[:i], ":/") {
+			name, addr = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		}
+		if name == "" || addr == "" {
+			return nil, nil, fmt.Errorf("invalid Marketplace source %q, it should be in the form of [<name>=]<address>", entry)
+		}
+		names = append(names, name)
+		addrs = append(addrs, addr)
+	}
+	return names, addrs, nil
+}
+
+// ParsePins parses plugin pins from s in the form of "jira:internal, github:internal".
+func ParsePins(s string) (map[string]string, error) {
+	pins := make(map[string]string)
+	for _, entry := range xstrings.SplitList(s) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid plugin pin %q, it should be in the form of <plugin-id>:<source>", entry)
+		}
+		pins[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return pins, nil
+}
@@ -0,0 +1,110 @@
+package marketplace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+// listerFunc lists plugins by calling itself.
+type listerFunc func() (Plugins, error)
+
+func (f listerFunc) ListPlugins() (Plugins, error) {
+	return f()
+}
+
+// staticLister lists plugins with ids and version.
+func staticLister(version string, ids ...string) Lister {
+	return listerFunc(func() (Plugins, error) {
+		var plugins Plugins
+		for _, id := range ids {
+			plugins = append(plugins, &Plugin{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+				DownloadURL: version + "/" + id,
+				Manifest:    &model.Manifest{Id: id, Version: version},
+			}})
+		}
+		return plugins, nil
+	})
+}
+
+func TestCompositeListPlugins(t *testing.T) {
+	sources := []Source{
+		{Name: "internal", Lister: staticLister("1.0.0", "jira", "inhouse")},
+		{Name: "public", Lister: staticLister("2.0.0", "jira", "github", "zoom")},
+	}
+
+	composite, err := NewComposite(sources, nil)
+	require.NoError(t, err)
+	plugins, err := composite.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 4)
+	jira, err := plugins.GetPlugin("jira")
+	require.NoError(t, err)
+	require.Equal(t, "1.0.0", jira.Manifest.Version) // from the source with a higher priority.
+
+	composite, err = NewComposite(sources, map[string]string{"jira": "public", "github": "internal"})
+	require.NoError(t, err)
+	plugins, err = composite.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 3)
+	jira, err = plugins.GetPlugin("jira")
+	require.NoError(t, err)
+	require.Equal(t, "2.0.0", jira.Manifest.Version)
+	_, err = plugins.GetPlugin("github") // pinned to a source that does not list it.
+	require.Equal(t, &NotFoundError{ID: "github"}, err)
+
+	// all versions listed by the same source are kept.
+	composite, err = NewComposite([]Source{
+		{Name: "internal", Lister: listerFunc(func() (Plugins, error) {
+			return Plugins{
+				{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "1.1.0-rc1"}}},
+				{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "1.0.0"}}},
+			}, nil
+		})},
+		{Name: "public", Lister: staticLister("2.0.0", "jira")},
+	}, nil)
+	require.NoError(t, err)
+	plugins, err = composite.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 2)
+	require.Equal(t, "1.1.0-rc1", plugins[0].Manifest.Version)
+	require.Equal(t, "1.0.0", plugins[1].Manifest.Version)
+}
+
+func TestCompositeListPluginsError(t *testing.T) {
+	composite, err := NewComposite([]Source{
+		{Name: "internal", Lister: listerFunc(func() (Plugins, error) { return nil, errors.New("gone bad!") })},
+		{Name: "public", Lister: staticLister("2.0.0", "inhouse")},
+	}, nil)
+	require.NoError(t, err)
+	_, err = composite.ListPlugins()
+	require.EqualError(t, err, `cannot list plugins of "internal" Marketplace: gone bad!`)
+}
+
+func TestNewCompositeInvalid(t *testing.T) {
+	_, err := NewComposite([]Source{{Name: "public"}, {Name: "public"}}, nil)
+	require.Error(t, err)
+	_, err = NewComposite([]Source{{Name: "public"}}, map[string]string{"jira": "internal"})
+	require.Error(t, err)
+}
+
+func TestParseSources(t *testing.T) {
+	names, addrs, err := ParseSources("internal=https://marketplace.example.com, https://api.example.com/?a=b, file:///opt/plugins")
+	require.NoError(t, err)
+	require.Equal(t, []string{"internal", "https://api.example.com/?a=b", "file:///opt/plugins"}, names)
+	require.Equal(t, []string{"https://marketplace.example.com", "https://api.example.com/?a=b", "file:///opt/plugins"}, addrs)
+
+	_, _, err = ParseSources("internal=")
+	require.Error(t, err)
+}
+
+func TestParsePins(t *testing.T) {
+	pins, err := ParsePins("jira:internal, github:https://api.example.com")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"jira": "internal", "github": "https://api.example.com"}, pins)
+
+	_, err = ParsePins("jira")
+	require.Error(t, err)
+}
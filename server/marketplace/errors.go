@@ -0,0 +1,96 @@
+package marketplace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrorBodyLength is the max length of a response body kept in an APIError.
+const maxErrorBodyLength = 512
+
+// APIError is returned when the Marketplace API responds with an unexpected status code.
+type APIError struct {
+	// StatusCode of the response.
+	StatusCode int
+
+	// Endpoint that is requested.
+	Endpoint string
+
+	// Message is the error message decoded from a JSON response.
+	Message string
+
+	// Body of the response truncated to a max length.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	detail := e.Message
+	if detail == "" {
+		detail = e.Body
+	}
+	if detail == "" {
+		detail = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("Marketplace API %q responded with %d: %s", e.Endpoint, e.StatusCode, detail)
+}
+
+// Retryable reports if the request might succeed on retry, which means that the Marketplace is
+// down or rate limiting rather than rejecting the request.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// PaginationError is returned when listing plugins does not end in the max number of pages.
+type PaginationError struct {
+	// Pages is the number of requested pages.
+	Pages int
+}
+
+func (e *PaginationError) Error() string {
+	return fmt.Sprintf("Marketplace API listed more than %d pages of plugins", e.Pages)
+}
+
+// Retryable reports false since the listing would not end on retry either.
+func (e *PaginationError) Retryable() bool {
+	return false
+}
+
+// apiErrorPayload is the JSON error payload of the Marketplace API.
+type apiErrorPayload struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// newAPIError creates an APIError from the response of endpoint.
+func newAPIError(endpoint string, resp *http.Response) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode, Endpoint: endpoint}
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength+1))
+	var payload apiErrorPayload
+	if err := json.Unmarshal(data, &payload); err == nil {
+		e.Message = payload.Message
+		if e.Message == "" {
+			e.Message = payload.Error
+		}
+	}
+	truncated := len(data) > maxErrorBodyLength
+	if truncated {
+		// cut on a rune boundary so a multi-byte rune is not split.
+		n := maxErrorBodyLength
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		data = data[:n]
+	}
+	// multiline bodies like HTML pages are collapsed to a single line.
+	body := strings.Join(strings.Fields(string(data)), " ")
+	if truncated {
+		body += "..."
+	}
+	e.Body = body
+	return e
+}
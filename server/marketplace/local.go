@@ -0,0 +1,126 @@
+package marketplace
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// bundleExtensions are the file extensions of plugin bundles in a local Marketplace.
+var bundleExtensions = []string{".tar.gz", ".tgz"}
+
+// Local is a Marketplace that lists plugin bundles in a local directory.
+// it's used by servers that cannot reach a Marketplace API, new versions of plugins
+// are rolled out by dropping their bundles into the directory.
+type Local struct {
+	// dir keeps the plugin bundles.
+	dir string
+}
+
+// IsLocal checks if addr is a local directory rather than a Marketplace API address.
+// addr is local when it's a file:// URL or an absolute path.
+func IsLocal(addr string) bool {
+	return strings.HasPrefix(addr, "file://") || filepath.IsAbs(addr)
+}
+
+// NewLocal creates a new Local Marketplace with a directory path or a file:// URL addr.
+func NewLocal(addr string) *Local {
+	dir := addr
+	if u, err := url.Parse(addr); err == nil && u.Scheme == "file" {
+		dir = xplugin.FileURLPath(u)
+	}
+	return &Local{dir: dir}
+}
+
+// LocalDirs returns the directory of bundles so they can be downloaded with file:// URLs.
+func (l *Local) LocalDirs() []string {
+	dir, err := filepath.Abs(l.dir)
+	if err != nil {
+		return nil
+	}
+	return []string{dir}
+}
+
+// ListPlugins lists all versions of plugins in the directory, newer versions of a plugin are
+// listed before the older ones. manifests of plugins are read from their bundles and bundles are
+// downloaded from the directory with file:// URLs.
+// files that are not valid plugin bundles or do not have a semver version are ignored.
+func (l *Local) ListPlugins() (Plugins, error) {
+	dir, err := filepath.Abs(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list the local Marketplace")
+	}
+	var plugins Plugins
+	versions := make(map[*Plugin]semver.Version)
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !isBundleName(file.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		manifest, err := readBundleManifest(path)
+		if err != nil {
+			continue
+		}
+		version, err := semver.Parse(manifest.Version)
+		if err != nil {
+			continue
+		}
+		plugin := &Plugin{
+			BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+				HomepageURL: manifest.HomepageURL,
+				DownloadURL: xplugin.FileURL(path),
+				Manifest:    manifest,
+			},
+			// bundles are published by dropping them into the directory.
+			UpdatedAt: file.ModTime(),
+		}
+		plugins = append(plugins, plugin)
+		versions[plugin] = version
+	}
+	sort.SliceStable(plugins, func(i, j int) bool {
+		if plugins[i].Manifest.Id != plugins[j].Manifest.Id {
+			return plugins[i].Manifest.Id < plugins[j].Manifest.Id
+		}
+		return versions[plugins[i]].GT(versions[plugins[j]])
+	})
+	return plugins, nil
+}
+
+// isBundleName checks if name is a file name of a plugin bundle.
+func isBundleName(name string) bool {
+	for _, ext := range bundleExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBundleManifest reads the manifest of the plugin bundle at path.
+func readBundleManifest(path string) (*model.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest, err := xplugin.ReadManifest(f)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Id == "" {
+		return nil, errors.New("plugin id is missing")
+	}
+	return manifest, nil
+}
@@ -0,0 +1,70 @@
+package marketplace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalListPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "marketplace-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeBundle(t, dir, "topdf-1.2.0.tar.gz", "topdf", "1.2.0")
+	writeBundle(t, dir, "topdf-1.3.0.tgz", "topdf", "1.3.0")
+	writeBundle(t, dir, "topdf-1.1.0.tar.gz", "topdf", "1.1.0")
+	writeBundle(t, dir, "jira-2.0.0.tar.gz", "jira", "2.0.0")
+	writeBundle(t, dir, "github-latest.tar.gz", "github", "latest")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.tar.gz"), []byte("broken"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("# plugins"), 0644))
+
+	for _, addr := range []string{dir, "file://" + filepath.ToSlash(dir)} {
+		require.True(t, IsLocal(addr))
+		plugins, err := NewLocal(addr).ListPlugins()
+		require.NoError(t, err)
+		require.Len(t, plugins, 4)
+		require.Equal(t, "jira", plugins[0].Manifest.Id)
+		require.Equal(t, "1.3.0", plugins[1].Manifest.Version)
+		require.Equal(t, "1.2.0", plugins[2].Manifest.Version)
+		require.Equal(t, "1.1.0", plugins[3].Manifest.Version)
+
+		jira, err := plugins.GetPlugin("jira")
+		require.NoError(t, err)
+		require.Equal(t, "2.0.0", jira.Manifest.Version)
+
+		topdf, err := plugins.GetPlugin("topdf")
+		require.NoError(t, err)
+		require.Equal(t, "1.3.0", topdf.Manifest.Version)
+		require.Equal(t, "file://"+filepath.ToSlash(filepath.Join(dir, "topdf-1.3.0.tgz")), topdf.DownloadURL)
+
+		local := NewLocal(addr)
+		require.Equal(t, []string{dir}, local.LocalDirs())
+		bundle, err := xplugin.NewDownloader(xplugin.LocalDirsOption(local.LocalDirs()...)).Download(context.Background(), topdf.DownloadURL, "topdf")
+		require.NoError(t, err)
+		require.Equal(t, "1.3.0", bundle.Manifest.Version)
+		require.NoError(t, bundle.Close())
+	}
+
+	require.False(t, IsLocal("https://api.integrations.mattermost.com"))
+	_, err = NewLocal(filepath.Join(dir, "missing")).ListPlugins()
+	require.Error(t, err)
+}
+
+// writeBundle writes a bundle of version of plugin with id to dir with name.
+func writeBundle(t *testing.T, dir, name, id, version string) {
+	pluginDir, err := ioutil.TempDir("", "plugin-")
+	require.NoError(t, err)
+	defer os.RemoveAll(pluginDir)
+	manifest := fmt.Sprintf(`{"id": %q, "version": %q}`, id, version)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "plugin.json"), []byte(manifest), 0644))
+	bundle, err := xplugin.BundleFromDir(pluginDir)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), bundle, 0644))
+}
@@ -1,14 +1,13 @@
 package marketplace
 
 import (
-	"errors"
-	"io/ioutil"
+	"encoding/json"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 
 	"time"
-
-	"github.com/mattermost/mattermost-server/model"
 )
 
 const (
@@ -19,6 +18,13 @@ const (
 const (
 	// requestTimeout is used as a timeout value to cancel long running request made to Marketplace API.
 	requestTimeout = time.Second * 10
+
+	// defaultPerPage is the default number of plugins requested per page.
+	defaultPerPage = 100
+
+	// maxPages is the max number of pages requested while listing plugins. it guards against
+	// Marketplaces that ignore the pagination parameters.
+	maxPages = 100
 )
 
 // Marketplace is a gateway to interact with Mattermost Plugin Marketplace.
@@ -28,36 +34,210 @@ type Marketplace struct {
 
 	// client is used to perform HTTP requests to Marketplace server.
 	client *http.Client
+
+	// serverVersion is the Mattermost server's version sent to only list compatible plugins.
+	serverVersion string
+
+	// filter is sent to only list plugins that matches with it.
+	filter string
+
+	// perPage is the number of plugins requested per page.
+	perPage int
+
+	// cache keeps the last listing.
+	cache *cache
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// Option used to configure a Marketplace.
+type Option func(*Marketplace)
+
+// ServerVersionOption sets the Mattermost server's version so only compatible plugins are listed.
+func ServerVersionOption(version string) Option {
+	return func(m *Marketplace) {
+		m.serverVersion = version
+	}
+}
+
+// FilterOption sets a filter so only plugins that match with it are listed.
+func FilterOption(filter string) Option {
+	return func(m *Marketplace) {
+		m.filter = filter
+	}
+}
+
+// PerPageOption sets the number of plugins requested per page.
+func PerPageOption(perPage int) Option {
+	return func(m *Marketplace) {
+		if perPage > 0 {
+			m.perPage = perPage
+		}
+	}
+}
+
+// TransportOption sets the HTTP transport used to request the Marketplace.
+func TransportOption(transport http.RoundTripper) Option {
+	return func(m *Marketplace) {
+		m.client.Transport = transport
+	}
+}
+
+// TimeoutOption sets the max time that a request to the Marketplace can take.
+func TimeoutOption(timeout time.Duration) Option {
+	return func(m *Marketplace) {
+		if timeout > 0 {
+			m.client.Timeout = timeout
+		}
+	}
+}
+
+// CacheOption sets how long a listing is served from the cache without requesting the Marketplace
+// with ttl and how long it's served when the Marketplace fails with staleIfError.
+// pages are always requested with their ETags and modification times so unmodified ones are not
+// downloaded again.
+func CacheOption(ttl, staleIfError time.Duration) Option {
+	return func(m *Marketplace) {
+		m.cache.ttl = ttl
+		m.cache.staleIfError = staleIfError
+	}
 }
 
 // New creates a new Marketplace with given Marketplace address addr.
-func New(addr string) *Marketplace {
-	return &Marketplace{
-		addr:   addr,
-		client: &http.Client{Timeout: requestTimeout},
+func New(addr string, options ...Option) *Marketplace {
+	m := &Marketplace{
+		addr:    addr,
+		client:  &http.Client{Timeout: requestTimeout},
+		perPage: defaultPerPage,
+		cache:   &cache{},
+		now:     time.Now,
+	}
+	for _, o := range options {
+		o(m)
 	}
+	return m
 }
 
-// ListPlugins fetches all plugins from the Marketplace.
+// ListPlugins fetches all plugins from the Marketplace by iterating through all pages.
+// listings are served from the cache while it's fresh or when the Marketplace fails.
 func (m *Marketplace) ListPlugins() (Plugins, error) {
+	m.cache.mu.Lock()
+	defer m.cache.mu.Unlock()
+	now := m.now()
+	if m.cache.fresh(now) {
+		atomic.AddInt64(&m.cache.stats.Hits, 1)
+		return m.cache.plugins, nil
+	}
+	pages, plugins, err := m.listPages()
+	if err != nil {
+		if m.cache.usable(now) {
+			atomic.AddInt64(&m.cache.stats.StaleHits, 1)
+			return m.cache.plugins, nil
+		}
+		return nil, err
+	}
+	m.cache.update(pages, plugins, now)
+	return plugins, nil
+}
+
+// CacheStats returns the metrics of the listing cache.
+func (m *Marketplace) CacheStats() CacheStats {
+	return m.cache.snapshot()
+}
+
+// listPages fetches all pages from the Marketplace. a *PaginationError is returned when the
+// pages do not end in maxPages.
+func (m *Marketplace) listPages() (map[int]*cachedPage, Plugins, error) {
+	var plugins Plugins
+	var previous *cachedPage
+	pages := make(map[int]*cachedPage)
+	for number := 0; number < maxPages; number++ {
+		page, err := m.listPage(number)
+		if err != nil {
+			return nil, nil, err
+		}
+		// a page that repeats the previous one means that pagination is not supported.
+		if previous != nil && samePlugins(previous.plugins, page.plugins) {
+			return pages, plugins, nil
+		}
+		pages[number] = page
+		plugins = append(plugins, page.plugins...)
+		// a short page is the last one and a long page means that pagination is not supported.
+		if len(page.plugins) != m.perPage {
+			return pages, plugins, nil
+		}
+		previous = page
+	}
+	return nil, nil, &PaginationError{Pages: maxPages}
+}
+
+// samePlugins checks if a and b list the same versions of plugins in the same order.
+func samePlugins(a, b Plugins) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Manifest.Id != b[i].Manifest.Id || a[i].Manifest.Version != b[i].Manifest.Version ||
+			a[i].DownloadURL != b[i].DownloadURL {
+			return false
+		}
+	}
+	return true
+}
+
+// listPage fetches plugins in page from the Marketplace.
+// the page is requested conditionally when it's in the cache.
+func (m *Marketplace) listPage(page int) (*cachedPage, error) {
 	urlParsed, err := url.Parse(m.addr)
 	if err != nil {
 		return nil, err
 	}
 	urlParsed.Path = listPluginsEndpoint
-	resp, err := m.client.Get(urlParsed.String())
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(m.perPage))
+	if m.serverVersion != "" {
+		query.Set("server_version", m.serverVersion)
+	}
+	if m.filter != "" {
+		query.Set("filter", m.filter)
+	}
+	urlParsed.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, urlParsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	cached := m.cache.page(page)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := m.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		atomic.AddInt64(&m.cache.stats.Revalidations, 1)
+		return cached, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		var data []byte
-		data, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(string(data))
+		urlParsed.RawQuery = ""
+		return nil, newAPIError(urlParsed.String(), resp)
+	}
+	var plugins Plugins
+	if err := json.NewDecoder(resp.Body).Decode(&plugins); err != nil {
+		return nil, err
 	}
-	plugins, err := model.BaseMarketplacePluginsFromReader(resp.Body)
-	return plugins, err
+	atomic.AddInt64(&m.cache.stats.Misses, 1)
+	return &cachedPage{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		plugins:      plugins,
+	}, nil
 }
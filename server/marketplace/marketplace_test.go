@@ -2,9 +2,12 @@ package marketplace
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/stretchr/testify/require"
@@ -14,9 +17,12 @@ func TestListPlugins(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		require.Equal(t, "/api/v1/plugins", r.URL.Path)
 		require.Equal(t, "GET", r.Method)
-		data, _ := json.Marshal([]*model.BaseMarketplacePlugin{
-			{Manifest: &model.Manifest{Id: "1"}},
-			{Manifest: &model.Manifest{Id: "2"}},
+		data, _ := json.Marshal([]*Plugin{
+			{
+				BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "1"}},
+				UpdatedAt:             time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC),
+			},
+			{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "2"}}},
 		})
 		w.Write(data)
 	}))
@@ -27,6 +33,8 @@ func TestListPlugins(t *testing.T) {
 	require.Len(t, plugins, 2)
 	require.Equal(t, "1", plugins[0].Manifest.Id)
 	require.Equal(t, "2", plugins[1].Manifest.Id)
+	require.Equal(t, time.Date(2019, 11, 20, 10, 0, 0, 0, time.UTC), plugins[0].UpdatedAt)
+	require.True(t, plugins[1].UpdatedAt.IsZero())
 	plugin1, err := plugins.GetPlugin("1")
 	require.NoError(t, err)
 	require.Equal(t, "1", plugin1.Manifest.Id)
@@ -45,5 +53,191 @@ func TestListPluginsBadStatus(t *testing.T) {
 	defer ts.Close()
 	marketplace := New(ts.URL)
 	_, err := marketplace.ListPlugins()
-	require.Equal(t, "gone bad!", err.Error())
+	require.Equal(t, &APIError{
+		StatusCode: http.StatusInternalServerError,
+		Endpoint:   ts.URL + "/api/v1/plugins",
+		Body:       "gone bad!",
+	}, err)
+	require.True(t, err.(*APIError).Retryable())
+	require.Equal(t, fmt.Sprintf(`Marketplace API "%s/api/v1/plugins" responded with 500: gone bad!`, ts.URL), err.Error())
+}
+
+func TestListPluginsAPIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("server_version") {
+		case "json":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "invalid server version"}`))
+		case "unicode":
+			// the max length falls in the middle of a two-byte rune.
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("x" + strings.Repeat("é", maxErrorBodyLength)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<html>\n<body>" + strings.Repeat("not found ", 100) + "</body>\n</html>"))
+		}
+	}))
+	defer ts.Close()
+
+	_, err := New(ts.URL, ServerVersionOption("json")).ListPlugins()
+	apiErr, ok := err.(*APIError)
+	require.True(t, ok)
+	require.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	require.Equal(t, "invalid server version", apiErr.Message)
+	require.False(t, apiErr.Retryable())
+
+	_, err = New(ts.URL).ListPlugins()
+	apiErr, ok = err.(*APIError)
+	require.True(t, ok)
+	require.Empty(t, apiErr.Message)
+	require.True(t, strings.HasPrefix(apiErr.Body, "<html> <body>not found"))
+	require.True(t, strings.HasSuffix(apiErr.Body, "..."))
+	require.Len(t, apiErr.Body, maxErrorBodyLength+len("..."))
+
+	_, err = New(ts.URL, ServerVersionOption("unicode")).ListPlugins()
+	apiErr, ok = err.(*APIError)
+	require.True(t, ok)
+	require.Equal(t, "x"+strings.Repeat("é", (maxErrorBodyLength-1)/2)+"...", apiErr.Body)
+}
+
+func TestListPluginsPages(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		query := r.URL.Query()
+		require.Equal(t, "5.18.0", query.Get("server_version"))
+		require.Equal(t, "jira", query.Get("filter"))
+		require.Equal(t, "2", query.Get("per_page"))
+		var plugins []*model.BaseMarketplacePlugin
+		switch query.Get("page") {
+		case "0":
+			plugins = append(plugins, &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "1"}},
+				&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "2"}})
+		case "1":
+			plugins = append(plugins, &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "3"}})
+		default:
+			require.Fail(t, "unexpected page", query.Get("page"))
+		}
+		data, _ := json.Marshal(plugins)
+		w.Write(data)
+	}))
+	defer ts.Close()
+	marketplace := New(ts.URL, ServerVersionOption("5.18.0"), FilterOption("jira"), PerPageOption(2))
+	plugins, err := marketplace.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 3)
+	require.Equal(t, "3", plugins[2].Manifest.Id)
+	require.Equal(t, 2, requests)
+}
+
+func TestListPluginsWithoutPagination(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, _ := json.Marshal([]*model.BaseMarketplacePlugin{
+			{Manifest: &model.Manifest{Id: "1"}},
+			{Manifest: &model.Manifest{Id: "2"}},
+			{Manifest: &model.Manifest{Id: "3"}},
+		})
+		w.Write(data)
+	}))
+	defer ts.Close()
+	marketplace := New(ts.URL, PerPageOption(2))
+	plugins, err := marketplace.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 3)
+	require.Equal(t, 1, requests)
+}
+
+func TestListPluginsRepeatedPages(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, _ := json.Marshal([]*model.BaseMarketplacePlugin{
+			{Manifest: &model.Manifest{Id: "1"}},
+			{Manifest: &model.Manifest{Id: "2"}},
+		})
+		w.Write(data)
+	}))
+	defer ts.Close()
+	marketplace := New(ts.URL, PerPageOption(2))
+	plugins, err := marketplace.ListPlugins()
+	require.NoError(t, err)
+	require.Len(t, plugins, 2)
+	require.Equal(t, 2, requests)
+}
+
+func TestListPluginsMaxPages(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		page := r.URL.Query().Get("page")
+		data, _ := json.Marshal([]*model.BaseMarketplacePlugin{{Manifest: &model.Manifest{Id: page}}})
+		w.Write(data)
+	}))
+	defer ts.Close()
+	marketplace := New(ts.URL, PerPageOption(1))
+	_, err := marketplace.ListPlugins()
+	require.Equal(t, &PaginationError{Pages: maxPages}, err)
+	require.False(t, err.(*PaginationError).Retryable())
+	require.Equal(t, maxPages, requests)
+}
+
+func TestListPluginsCache(t *testing.T) {
+	var requests int
+	var fail bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		data, _ := json.Marshal([]*model.BaseMarketplacePlugin{{Manifest: &model.Manifest{Id: "1"}}})
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	marketplace := New(ts.URL, CacheOption(time.Minute, time.Hour))
+	marketplace.now = func() time.Time { return now }
+	listPlugins := func() {
+		plugins, err := marketplace.ListPlugins()
+		require.NoError(t, err)
+		require.Len(t, plugins, 1)
+		require.Equal(t, "1", plugins[0].Manifest.Id)
+	}
+
+	// the first listing is downloaded.
+	listPlugins()
+	require.Equal(t, 1, requests)
+	require.Equal(t, CacheStats{Misses: 1}, marketplace.CacheStats())
+
+	// fresh listings are served without requests.
+	now = now.Add(time.Second * 30)
+	listPlugins()
+	require.Equal(t, 1, requests)
+	require.Equal(t, CacheStats{Misses: 1, Hits: 1}, marketplace.CacheStats())
+
+	// expired listings are revalidated.
+	now = now.Add(time.Minute)
+	listPlugins()
+	require.Equal(t, 2, requests)
+	require.Equal(t, CacheStats{Misses: 1, Hits: 1, Revalidations: 1}, marketplace.CacheStats())
+
+	// listings are served from the cache when the Marketplace fails.
+	fail = true
+	now = now.Add(time.Minute * 30)
+	listPlugins()
+	require.Equal(t, 3, requests)
+	require.Equal(t, CacheStats{Misses: 1, Hits: 1, Revalidations: 1, StaleHits: 1}, marketplace.CacheStats())
+
+	// until they're too old.
+	now = now.Add(time.Hour)
+	_, err := marketplace.ListPlugins()
+	require.Error(t, err)
 }
@@ -2,15 +2,25 @@ package marketplace
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 )
 
+// Plugin is a Marketplace plugin.
+type Plugin struct {
+	*model.BaseMarketplacePlugin
+
+	// UpdatedAt is the time that the version of the plugin is published to the Marketplace at.
+	// it is zero when the Marketplace does not provide it.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // Plugins is a list of Marketplace plugins.
-type Plugins []*model.BaseMarketplacePlugin
+type Plugins []*Plugin
 
 // GetPlugin gets a plugin by id.
-func (p *Plugins) GetPlugin(id string) (*model.BaseMarketplacePlugin, error) {
+func (p *Plugins) GetPlugin(id string) (*Plugin, error) {
 	for _, plugin := range *p {
 		if plugin.Manifest.Id == id {
 			return plugin, nil
@@ -0,0 +1,173 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// formatNotification creates a human readable Markdown message from notification.
+func formatNotification(notification updater.Notification) string {
+	if notification.Updated != nil {
+		return formatUpdated(notification.PluginID, notification.Updated)
+	}
+	if notification.Queued != nil {
+		return formatQueued(notification.PluginID, notification.Queued, notification.QueuedUntil)
+	}
+	if notification.ApprovalRequested != nil {
+		return formatApprovalRequested(notification.PluginID, notification.ApprovalRequested)
+	}
+	if notification.Planned != nil {
+		return formatPlanned(notification.PluginID, notification.Planned, notification.Error)
+	}
+	switch e := errors.Cause(notification.Error).(type) {
+	case *updater.UpdatePolicyError:
+		return formatUpdateAvailable(e)
+	case *updater.RollbackError:
+		return formatRollback(e)
+	case *updater.BackupError:
+		return formatBackupError(e)
+	case *marketplace.APIError:
+		return formatMarketplaceError(e)
+	}
+	return formatError(notification.PluginID, notification.Error)
+}
+
+// formatUpdated creates a message about a successful update.
+func formatUpdated(pluginID string, changelog *updater.Changelog) string {
+	message := fmt.Sprintf("#### :white_check_mark: %s is updated\n", changelog.UpdatedName)
+	message += fmt.Sprintf("Plugin `%s` is updated from `%s` to `%s`.\n", pluginID,
+		changelog.PreviousVersion, changelog.UpdatedVersion)
+	if changelog.UpdatedDescription != "" {
+		message += fmt.Sprintf("\n> %s\n", changelog.UpdatedDescription)
+	}
+	return message
+}
+
+// formatQueued creates a message about an update that waits for a maintenance window.
+func formatQueued(pluginID string, changelog *updater.Changelog, until time.Time) string {
+	message := fmt.Sprintf("#### :hourglass: %s update is queued\n", changelog.UpdatedName)
+	message += fmt.Sprintf("Plugin `%s` will be updated from `%s` to `%s` in the maintenance window at %s.\n",
+		pluginID, changelog.PreviousVersion, changelog.UpdatedVersion, until.Format(time.RFC1123))
+	return message
+}
+
+// formatApprovalRequested creates a message about an update that waits for an approval.
+func formatApprovalRequested(pluginID string, changelog *updater.Changelog) string {
+	message := fmt.Sprintf("#### :raised_hand: %s update needs an approval\n", changelog.UpdatedName)
+	message += fmt.Sprintf("Plugin `%s` will be updated from `%s` to `%s` once it is approved.\n",
+		pluginID, changelog.PreviousVersion, changelog.UpdatedVersion)
+	if changelog.UpdatedDescription != "" {
+		message += fmt.Sprintf("\n> %s\n", changelog.UpdatedDescription)
+	}
+	return message
+}
+
+// approvalAttachments creates the Approve, Reject and Snooze buttons of an approval request that
+// are handled by url.
+func approvalAttachments(url string, notification updater.Notification) []*model.SlackAttachment {
+	action := func(name, decision string) *model.PostAction {
+		return &model.PostAction{
+			Name: name,
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Integration: &model.PostActionIntegration{
+				URL: url,
+				Context: map[string]interface{}{
+					ApprovalContextDecision: decision,
+					ApprovalContextPluginID: notification.PluginID,
+					ApprovalContextVersion:  notification.ApprovalRequested.UpdatedVersion,
+				},
+			},
+		}
+	}
+	return []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			action("Approve", DecisionApprove),
+			action("Reject", DecisionReject),
+			action("Snooze", DecisionSnooze),
+		},
+	}}
+}
+
+// formatPlanned creates a message about an update that would be made in dry-run mode.
+// err is the reason when the update would not be made.
+func formatPlanned(pluginID string, changelog *updater.Changelog, err error) string {
+	if err == nil {
+		return fmt.Sprintf("#### :mag: Dry run: %s would be updated\nPlugin `%s` would be updated from `%s` to `%s`.\n",
+			pluginID, pluginID, changelog.PreviousVersion, changelog.UpdatedVersion)
+	}
+	message := fmt.Sprintf("#### :mag: Dry run: %s would not be updated\n", pluginID)
+	message += fmt.Sprintf("Plugin `%s` would not be updated from `%s` to `%s`:\n", pluginID,
+		changelog.PreviousVersion, changelog.UpdatedVersion)
+	message += fmt.Sprintf("```\n%s\n```\n", err)
+	return message
+}
+
+// formatUpdateAvailable creates a message about an update that is blocked by the update policy.
+func formatUpdateAvailable(e *updater.UpdatePolicyError) string {
+	message := fmt.Sprintf("#### :arrow_up: %s update available for %s\n", strings.Title(string(e.RequiredPolicy)), e.PluginID)
+	message += fmt.Sprintf("Plugin `%s` can be updated from `%s` to `%s` but its update policy only allows %s updates.\n",
+		e.PluginID, e.CurrentPluginVersion, e.NextPluginVersion, e.Policy)
+	message += "Review the release notes and update the plugin manually or change its update policy.\n"
+	return message
+}
+
+// formatRollback creates a message about an update that is rolled back.
+func formatRollback(e *updater.RollbackError) string {
+	message := fmt.Sprintf("#### :rewind: %s is rolled back\n", e.PluginID)
+	message += fmt.Sprintf("Plugin `%s` is updated to `%s` but it is rolled back to `%s` because it failed the health checks.\n",
+		e.PluginID, e.FailedPluginVersion, e.RestoredPluginVersion)
+	message += fmt.Sprintf("```\n%s\n```\n", e.Err)
+	message += "**Action required:** check the server logs before trying to update the plugin again.\n"
+	return message
+}
+
+// formatBackupError creates a message about an update that is installed without a backup.
+func formatBackupError(e *updater.BackupError) string {
+	message := fmt.Sprintf("#### :warning: %s cannot be backed up\n", e.PluginID)
+	message += fmt.Sprintf("Plugin `%s` is updated to `%s` without a backup, it cannot be rolled back if the new version fails the health checks.\n",
+		e.PluginID, e.NextPluginVersion)
+	message += fmt.Sprintf("```\n%s\n```\n", e.Err)
+	message += "**Action required:** check the server logs and the plugin's status in the System Console after the update.\n"
+	return message
+}
+
+// formatMarketplaceError creates a message about a failed request to the Marketplace API.
+func formatMarketplaceError(e *marketplace.APIError) string {
+	if e.Retryable() {
+		message := "#### :construction: Marketplace is unavailable\n"
+		message += fmt.Sprintf("```\n%s\n```\n", e)
+		message += "Updates will be checked again on the next check.\n"
+		return message
+	}
+	message := "#### :no_entry: Marketplace rejected the request\n"
+	message += fmt.Sprintf("```\n%s\n```\n", e)
+	message += "**Action required:** check the Marketplace address and the HTTP settings of the addon in the System Console.\n"
+	return message
+}
+
+// formatError creates a message about a failed update.
+// errors that need an action from admins are explained with a hint.
+func formatError(pluginID string, err error) string {
+	message := fmt.Sprintf("#### :warning: %s cannot be updated\n", pluginID)
+	if err == nil {
+		return message
+	}
+	message += fmt.Sprintf("```\n%s\n```\n", err)
+	switch e := errors.Cause(err).(type) {
+	case *updater.ServerVersionError:
+		message += fmt.Sprintf("**Action required:** upgrade Mattermost Server to `%s` or later to install `%s` version of the plugin.\n",
+			e.RequiredServerVersion, e.NextPluginVersion)
+	case *updater.VerificationError:
+		message += fmt.Sprintf("**Action required:** the downloaded bundle of `%s` version may be tampered with, make sure it is published by a trusted source before installing it manually.\n",
+			e.NextPluginVersion)
+	default:
+		message += "**Action required:** check the server logs and the plugin's status in the System Console.\n"
+	}
+	return message
+}
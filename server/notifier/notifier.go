@@ -1,33 +1,336 @@
+// Package notifier delivers plugin update notifications produced by the updater to Mattermost.
 package notifier
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/pkg/errors"
 )
 
 // Notifier notifies Mattermost admins and channels with plugin updates or failures.
-type Notifier struct{}
+type Notifier struct {
+	// papi use to access Mattermost API features.
+	papi plugin.API
+
+	mc sync.RWMutex // protects config.
+	// config holds configs set as options.
+	conf *config
+
+	mch sync.Mutex // protects channelID and channelName.
+	// channelID is the cached id of the notification channel with channelName. it is reset when
+	// the config is updated or posting to the channel fails.
+	channelID   string
+	channelName string
+}
+
+// config holds configs set as options.
+type config struct {
+	// botUserID is the id of the bot user that notifications are posted as.
+	botUserID string
+
+	// channelName is the name of the Mattermost #channel that notifications are posted to.
+	channelName string
+
+	// admins defines which system admins receive notifications as direct messages.
+	admins AdminsMode
+
+	// adminUsernames is a list of system admins to send direct messages to when admins
+	// is set to AdminsSelected.
+	adminUsernames []string
+
+	// approvalURL is the URL that handles the buttons of approval requests.
+	approvalURL string
+}
+
+// AdminsMode defines which system admins receive notifications as direct messages.
+type AdminsMode string
+
+const (
+	// AdminsAll sends direct messages to all system admins.
+	AdminsAll AdminsMode = "all"
+
+	// AdminsSelected sends direct messages only to the listed system admins.
+	AdminsSelected AdminsMode = "selected"
+
+	// AdminsOff disables sending direct messages to system admins.
+	AdminsOff AdminsMode = "off"
+)
+
+const (
+	// ApprovalContextDecision, ApprovalContextPluginID and ApprovalContextVersion are the keys
+	// in the context of the approval request buttons.
+	ApprovalContextDecision = "decision"
+	ApprovalContextPluginID = "plugin_id"
+	ApprovalContextVersion  = "version"
+
+	// DecisionApprove, DecisionReject and DecisionSnooze are the decisions of the approval
+	// request buttons.
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+	DecisionSnooze  = "snooze"
+)
+
+const (
+	// usersPerPage is the page size used while listing system admins.
+	usersPerPage = 100
+)
 
 // New creates a new Notifier with papi and notifications chan.
 // notifier consumes notifications from the notifications chan and sends notifications to Mattermost
 // admins and to user given Mattermost notification #channel.
-func New(papi plugin.API, notifications chan updater.Notification, options ...Option) *Notifier {
-	go func() {
-		for a := range notifications {
-			fmt.Println("notification", a.PluginID)
-		}
-	}()
-	return &Notifier{}
+// notifier stops consuming once the notifications chan is closed.
+func New(papi plugin.API, notifications <-chan updater.Notification, options ...Option) *Notifier {
+	n := &Notifier{
+		papi: papi,
+		conf: &config{},
+	}
+	n.UpdateConfig(options...)
+	if notifications != nil {
+		go n.listen(notifications)
+	}
+	return n
 }
 
 // UpdateConfig updates notifier's options configs set during the first initialization.
-func (n *Notifier) UpdateConfig(options ...Option) {}
+func (n *Notifier) UpdateConfig(options ...Option) {
+	n.mc.Lock()
+	defer n.mc.Unlock()
+	for _, o := range options {
+		o(n)
+	}
+	n.resetChannel("")
+}
+
+// cloneConfig gets a snapshot of config's current state.
+func (n *Notifier) cloneConfig() config {
+	n.mc.RLock()
+	defer n.mc.RUnlock()
+	return *n.conf
+}
 
 // Option modifies Notifier's configurations.
-type Option (*Notifier)
+type Option func(*Notifier)
 
 // NotificationChannelNameOption creates a new option to set a Mattermost #channel to send
 // notifications to.
-func NotificationChannelNameOption(channel string) Option { return nil }
+// channel is the name of the channel as seen in its URL, it'll be created if it does not exist.
+// notifications are not sent to any channel when channel is empty.
+func NotificationChannelNameOption(channel string) Option {
+	return func(n *Notifier) {
+		n.conf.channelName = channel
+	}
+}
+
+// AdminsOption sets which system admins receive notifications as direct messages.
+// usernames is only used with AdminsSelected mode and users in it that are not system
+// admins are ignored.
+func AdminsOption(mode AdminsMode, usernames []string) Option {
+	return func(n *Notifier) {
+		n.conf.admins = mode
+		n.conf.adminUsernames = usernames
+	}
+}
+
+// BotUserIDOption sets the bot user that notifications are posted as.
+func BotUserIDOption(id string) Option {
+	return func(n *Notifier) {
+		n.conf.botUserID = id
+	}
+}
+
+// ApprovalURLOption sets the URL that handles the Approve, Reject and Snooze buttons posted with
+// approval requests. it receives a model.PostActionIntegrationRequest with a context that has the
+// decision, plugin id and version. approval requests are posted without buttons when url is empty.
+func ApprovalURLOption(url string) Option {
+	return func(n *Notifier) {
+		n.conf.approvalURL = url
+	}
+}
+
+// listen consumes notifications until the notifications chan is closed.
+func (n *Notifier) listen(notifications <-chan updater.Notification) {
+	for notification := range notifications {
+		conf := n.cloneConfig()
+		if err := n.notifyChannel(conf, notification); err != nil {
+			n.papi.LogError(errors.Wrap(err, "cannot send notification to channel").Error())
+		}
+		if err := n.notifyAdmins(conf, notification); err != nil {
+			n.papi.LogError(errors.Wrap(err, "cannot send notification to admins").Error())
+		}
+	}
+}
+
+// notifyChannel sends notification to the notification #channel.
+func (n *Notifier) notifyChannel(conf config, notification updater.Notification) error {
+	if conf.channelName == "" {
+		return nil
+	}
+	if conf.botUserID == "" {
+		return errors.New("bot user is not set")
+	}
+	channelID, cached, err := n.notificationChannel(conf)
+	if err != nil {
+		return err
+	}
+	err = n.post(conf, channelID, notification)
+	if err != nil && cached {
+		// the cached channel might be deleted, look for it again.
+		n.resetChannel(channelID)
+		if channelID, _, err = n.notificationChannel(conf); err != nil {
+			return err
+		}
+		err = n.post(conf, channelID, notification)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot post to %q channel", conf.channelName)
+	}
+	return nil
+}
+
+// notificationChannel returns the id of the notification channel in conf and reports whether it
+// is cached. the channel is looked for only once unless the cache is reset.
+func (n *Notifier) notificationChannel(conf config) (id string, cached bool, err error) {
+	n.mch.Lock()
+	defer n.mch.Unlock()
+	if n.channelID != "" && n.channelName == conf.channelName {
+		return n.channelID, true, nil
+	}
+	channel, err := n.ensureChannel(conf.channelName, conf.botUserID)
+	if err != nil {
+		return "", false, err
+	}
+	n.channelID, n.channelName = channel.Id, conf.channelName
+	return channel.Id, false, nil
+}
+
+// resetChannel resets the cached notification channel if its id is channelID. it is always reset
+// when channelID is empty.
+func (n *Notifier) resetChannel(channelID string) {
+	n.mch.Lock()
+	defer n.mch.Unlock()
+	if channelID == "" || n.channelID == channelID {
+		n.channelID, n.channelName = "", ""
+	}
+}
+
+// notifyAdmins sends notification to system admins as direct messages.
+// it tries to send the message to every admin even if sending it to some of them fails.
+func (n *Notifier) notifyAdmins(conf config, notification updater.Notification) error {
+	if conf.admins == "" || conf.admins == AdminsOff {
+		return nil
+	}
+	if conf.botUserID == "" {
+		return errors.New("bot user is not set")
+	}
+	admins, err := n.listAdmins(conf)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, admin := range admins {
+		channel, aerr := n.papi.GetDirectChannel(conf.botUserID, admin.Id)
+		if aerr != nil {
+			n.papi.LogError(errors.Wrapf(aerr, "cannot get direct channel of %q", admin.Username).Error())
+			failed++
+			continue
+		}
+		if err := n.post(conf, channel.Id, notification); err != nil {
+			n.papi.LogError(errors.Wrapf(err, "cannot send direct message to %q", admin.Username).Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d direct messages cannot be sent", failed, len(admins))
+	}
+	return nil
+}
+
+// listAdmins lists system admins that should receive direct messages with conf.
+func (n *Notifier) listAdmins(conf config) ([]*model.User, error) {
+	switch conf.admins {
+	case AdminsAll:
+		var admins []*model.User
+		for page := 0; ; page++ {
+			users, aerr := n.papi.GetUsers(&model.UserGetOptions{
+				Role:    model.SYSTEM_ADMIN_ROLE_ID,
+				Page:    page,
+				PerPage: usersPerPage,
+			})
+			if aerr != nil {
+				return nil, errors.Wrap(aerr, "cannot get a list of system admins")
+			}
+			admins = append(admins, users...)
+			if len(users) < usersPerPage {
+				return admins, nil
+			}
+		}
+	case AdminsSelected:
+		if len(conf.adminUsernames) == 0 {
+			return nil, nil
+		}
+		users, aerr := n.papi.GetUsersByUsernames(conf.adminUsernames)
+		if aerr != nil {
+			return nil, errors.Wrap(aerr, "cannot get a list of selected system admins")
+		}
+		var admins []*model.User
+		for _, user := range users {
+			if !user.IsInRole(model.SYSTEM_ADMIN_ROLE_ID) {
+				n.papi.LogWarn(fmt.Sprintf("%q is not a system admin, skipping direct message", user.Username))
+				continue
+			}
+			admins = append(admins, user)
+		}
+		return admins, nil
+	default:
+		return nil, fmt.Errorf("unknown admins mode %q", conf.admins)
+	}
+}
+
+// post posts notification to channel as the bot user in conf.
+func (n *Notifier) post(conf config, channelID string, notification updater.Notification) error {
+	post := &model.Post{
+		UserId:    conf.botUserID,
+		ChannelId: channelID,
+		Message:   formatNotification(notification),
+	}
+	if notification.ApprovalRequested != nil && conf.approvalURL != "" {
+		model.ParseSlackAttachment(post, approvalAttachments(conf.approvalURL, notification))
+	}
+	_, aerr := n.papi.CreatePost(post)
+	if aerr != nil {
+		return aerr
+	}
+	return nil
+}
+
+// ensureChannel looks for a channel with name in all teams and returns the first one found.
+// if there is no such channel, it is created in the first team of the server by creatorID.
+func (n *Notifier) ensureChannel(name, creatorID string) (*model.Channel, error) {
+	teams, aerr := n.papi.GetTeams()
+	if aerr != nil {
+		return nil, errors.Wrap(aerr, "cannot get a list of teams")
+	}
+	if len(teams) == 0 {
+		return nil, errors.New("there are no teams to look for the notification channel")
+	}
+	for _, team := range teams {
+		if channel, aerr := n.papi.GetChannelByName(team.Id, name, false); aerr == nil {
+			return channel, nil
+		}
+	}
+	channel, aerr := n.papi.CreateChannel(&model.Channel{
+		TeamId:      teams[0].Id,
+		Name:        name,
+		DisplayName: name,
+		Type:        model.CHANNEL_OPEN,
+		CreatorId:   creatorID,
+	})
+	if aerr != nil {
+		return nil, errors.Wrapf(aerr, "cannot create %q channel", name)
+	}
+	return channel, nil
+}
@@ -0,0 +1,225 @@
+package notifier
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyUpdated(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetTeams").Return([]*model.Team{{Id: "team1"}, {Id: "team2"}}, nil)
+	apiMock.On("GetChannelByName", "team1", "updates", false).Return(nil, &model.AppError{})
+	apiMock.On("GetChannelByName", "team2", "updates", false).Return(&model.Channel{Id: "channel2"}, nil)
+	apiMock.On("CreatePost", mock.Anything).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		require.Equal(t, "bot", post.UserId)
+		require.Equal(t, "channel2", post.ChannelId)
+		require.Contains(t, post.Message, "TOPDF is updated")
+		require.Contains(t, post.Message, "from `1.2.1` to `1.3.0`")
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), NotificationChannelNameOption("updates"))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), updater.Notification{
+		PluginID: "topdf",
+		Updated: &updater.Changelog{
+			UpdatedName:     "TOPDF",
+			PreviousVersion: "1.2.1",
+			UpdatedVersion:  "1.3.0",
+		},
+	}))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifyApprovalRequested(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetTeams").Return([]*model.Team{{Id: "team1"}}, nil)
+	apiMock.On("GetChannelByName", "team1", "updates", false).Return(&model.Channel{Id: "channel1"}, nil)
+	apiMock.On("CreatePost", mock.Anything).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		require.Contains(t, post.Message, "TOPDF update needs an approval")
+		attachments := post.Attachments()
+		require.Len(t, attachments, 1)
+		require.Len(t, attachments[0].Actions, 3)
+		approve := attachments[0].Actions[0]
+		require.Equal(t, "Approve", approve.Name)
+		require.Equal(t, "/plugins/addon/approval", approve.Integration.URL)
+		require.Equal(t, map[string]interface{}{
+			ApprovalContextDecision: DecisionApprove,
+			ApprovalContextPluginID: "topdf",
+			ApprovalContextVersion:  "1.3.0",
+		}, approve.Integration.Context)
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), NotificationChannelNameOption("updates"),
+		ApprovalURLOption("/plugins/addon/approval"))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), updater.Notification{
+		PluginID: "topdf",
+		ApprovalRequested: &updater.Changelog{
+			UpdatedName:     "TOPDF",
+			PreviousVersion: "1.2.1",
+			UpdatedVersion:  "1.3.0",
+		},
+	}))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifyErrorCreatesChannel(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetTeams").Return([]*model.Team{{Id: "team1"}}, nil)
+	apiMock.On("GetChannelByName", "team1", "updates", false).Return(nil, &model.AppError{})
+	apiMock.On("CreateChannel", mock.Anything).Once().Return(&model.Channel{Id: "channel1"}, nil).Run(func(args mock.Arguments) {
+		channel := args.Get(0).(*model.Channel)
+		require.Equal(t, "team1", channel.TeamId)
+		require.Equal(t, "updates", channel.Name)
+		require.Equal(t, model.CHANNEL_OPEN, channel.Type)
+	})
+	apiMock.On("CreatePost", mock.Anything).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		require.Equal(t, "channel1", post.ChannelId)
+		require.Contains(t, post.Message, "topdf cannot be updated")
+		require.Contains(t, post.Message, "could not install the plugin")
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), NotificationChannelNameOption("updates"))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), updater.Notification{
+		PluginID: "topdf",
+		Error:    errors.New("could not install the plugin"),
+	}))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifyChannelCache(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetTeams").Twice().Return([]*model.Team{{Id: "team1"}}, nil)
+	apiMock.On("GetChannelByName", "team1", "updates", false).Once().Return(&model.Channel{Id: "channel1"}, nil)
+	apiMock.On("GetChannelByName", "team1", "updates", false).Once().Return(&model.Channel{Id: "channel2"}, nil)
+	var channels []string
+	apiMock.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		channels = append(channels, post.ChannelId)
+		return post
+	}, func(post *model.Post) *model.AppError {
+		// channel1 is deleted after the first post.
+		if post.ChannelId == "channel1" && len(channels) > 1 {
+			return model.NewAppError("CreatePost", "app.channel.get.existing.app_error", nil, "", http.StatusNotFound)
+		}
+		return nil
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), NotificationChannelNameOption("updates"))
+	notification := updater.Notification{PluginID: "topdf", Error: errors.New("could not install the plugin")}
+	// the channel is looked for once and again after posting to it fails.
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), notification))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), notification))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), notification))
+	require.Equal(t, []string{"channel1", "channel1", "channel2", "channel2"}, channels)
+
+	// the channel is looked for again once the config is updated.
+	apiMock.On("GetTeams").Once().Return([]*model.Team{{Id: "team1"}}, nil)
+	apiMock.On("GetChannelByName", "team1", "alerts", false).Once().Return(&model.Channel{Id: "channel3"}, nil)
+	n.UpdateConfig(NotificationChannelNameOption("alerts"))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), notification))
+	require.Equal(t, "channel3", channels[len(channels)-1])
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestFormatMarketplaceError(t *testing.T) {
+	message := formatNotification(updater.Notification{
+		Error: &marketplace.APIError{StatusCode: http.StatusUnauthorized, Endpoint: "/api/v1/plugins"},
+	})
+	require.Contains(t, message, "Marketplace rejected the request")
+	require.Contains(t, message, "Action required")
+
+	message = formatNotification(updater.Notification{
+		Error: &marketplace.APIError{StatusCode: http.StatusServiceUnavailable, Endpoint: "/api/v1/plugins"},
+	})
+	require.Contains(t, message, "Marketplace is unavailable")
+	require.NotContains(t, message, "Action required")
+}
+
+func TestFormatBackupError(t *testing.T) {
+	message := formatNotification(updater.Notification{
+		PluginID: "topdf",
+		Error:    &updater.BackupError{PluginID: "topdf", NextPluginVersion: "1.3.0", Err: errors.New("value too large")},
+	})
+	require.Contains(t, message, "topdf cannot be backed up")
+	require.Contains(t, message, "value too large")
+	require.Contains(t, message, "Action required")
+}
+
+func TestNotifyWithoutChannel(t *testing.T) {
+	apiMock := &apimock.API{}
+	n := New(apiMock, nil, BotUserIDOption("bot"))
+	require.NoError(t, n.notifyChannel(n.cloneConfig(), updater.Notification{PluginID: "topdf"}))
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifyAllAdmins(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetUsers", &model.UserGetOptions{
+		Role:    model.SYSTEM_ADMIN_ROLE_ID,
+		Page:    0,
+		PerPage: usersPerPage,
+	}).Return([]*model.User{{Id: "admin1"}, {Id: "admin2"}}, nil)
+	apiMock.On("GetDirectChannel", "bot", "admin1").Return(&model.Channel{Id: "dm1"}, nil)
+	apiMock.On("GetDirectChannel", "bot", "admin2").Return(&model.Channel{Id: "dm2"}, nil)
+	var channels []string
+	apiMock.On("CreatePost", mock.Anything).Twice().Return(nil, nil).Run(func(args mock.Arguments) {
+		post := args.Get(0).(*model.Post)
+		require.Contains(t, post.Message, "upgrade Mattermost Server to `5.18.0`")
+		channels = append(channels, post.ChannelId)
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), AdminsOption(AdminsAll, nil))
+	require.NoError(t, n.notifyAdmins(n.cloneConfig(), updater.Notification{
+		PluginID: "topdf",
+		Error: &updater.ServerVersionError{
+			PluginID:              "topdf",
+			NextPluginVersion:     "1.3.0",
+			CurrentServerVersion:  "5.17.0",
+			RequiredServerVersion: "5.18.0",
+		},
+	}))
+	require.Equal(t, []string{"dm1", "dm2"}, channels)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifySelectedAdmins(t *testing.T) {
+	apiMock := &apimock.API{}
+	apiMock.On("GetUsersByUsernames", []string{"alice", "bob"}).Return([]*model.User{
+		{Id: "alice", Username: "alice", Roles: "system_user system_admin"},
+		{Id: "bob", Username: "bob", Roles: "system_user"},
+	}, nil)
+	apiMock.On("LogWarn", `"bob" is not a system admin, skipping direct message`).Once()
+	apiMock.On("GetDirectChannel", "bot", "alice").Return(&model.Channel{Id: "dm1"}, nil)
+	apiMock.On("CreatePost", mock.Anything).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		require.Equal(t, "dm1", args.Get(0).(*model.Post).ChannelId)
+	})
+
+	n := New(apiMock, nil, BotUserIDOption("bot"), AdminsOption(AdminsSelected, []string{"alice", "bob"}))
+	require.NoError(t, n.notifyAdmins(n.cloneConfig(), updater.Notification{
+		PluginID: "topdf",
+		Error:    errors.New("could not install the plugin"),
+	}))
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestNotifyAdminsOff(t *testing.T) {
+	apiMock := &apimock.API{}
+	n := New(apiMock, nil, BotUserIDOption("bot"), AdminsOption(AdminsOff, []string{"alice"}))
+	require.NoError(t, n.notifyAdmins(n.cloneConfig(), updater.Notification{PluginID: "topdf"}))
+	apiMock.AssertExpectations(t)
+}
@@ -1,13 +1,41 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/notifier"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xhttp"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xtime"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// botUsername is the username of the bot user that notifications are sent from.
+	botUsername = "marketplace-addon"
+
+	// botDisplayName is the display name of the bot user.
+	botDisplayName = "Marketplace Addon"
+
+	// botDescription is the description of the bot user.
+	botDescription = "Keeps you posted about plugin updates made by the Marketplace Addon."
+
+	// retryJitter is the fraction of the wait time between retries that is randomized.
+	retryJitter = 0.2
+
+	// eventsBuffer is the buffer size of the lifecycle events that are logged.
+	eventsBuffer = 100
+
+	// notificationsBuffer is the buffer size of the notifications that wait to be sent by the notifier.
+	notificationsBuffer = 100
 )
 
 // Plugin is Marketplace Addon that auto-updates plugins installed to Mattermost server.
@@ -18,6 +46,13 @@ type Plugin struct {
 	updater *updater.Updater
 	// notifier used to send update notifications to admins and channels.
 	notifier *notifier.Notifier
+	// notifications is the subscription of the notifier to the updater's notifications.
+	notifications *updater.NotificationSubscription
+
+	// events is the subscription to the updater's lifecycle events that are logged.
+	events *updater.EventSubscription
+	// transport is the HTTP transport of the current configuration.
+	transport http.RoundTripper
 
 	// initialized keeps info about if all dependencies of this plugin are initialized or not.
 	// initialization should be redone everytime plugin is activated.
@@ -31,8 +66,39 @@ type Plugin struct {
 // see plugin.json at the root dir for getting more info about these configurations.
 type configuration struct {
 	MarketplaceAPIAddress   string
+	MarketplacePins         string
+	MarketplaceCacheTTL     xtime.Duration
+	MarketplaceStaleIfError xtime.Duration
+	MarketplaceTimeout      xtime.Duration
+	HTTPProxy               string
+	HTTPCACertificates      string
+	HTTPClientCertificate   string
+	HTTPClientKey           string
+	HTTPHeaders             string
+	HTTPConnectTimeout      xtime.Duration
 	NotificationChannelName string
+	AdminNotifications      string
+	AdminUsernames          string
 	UpdateCheckFrequency    xtime.Duration
+	UpdatePolicy            string
+	PluginUpdatePolicies    string
+	ReleaseChannel          string
+	PluginReleaseChannels   string
+	MinReleaseAge           xtime.Duration
+	PluginHolds             string
+	ApprovalMode            string
+	ApprovalPlugins         string
+	HealthCheckTimeout      xtime.Duration
+	DryRun                  bool
+	MaintenanceWindows      string
+	UpdateConcurrency       string
+	UpdateTimeout           xtime.Duration
+	RetryAttempts           string
+	RetryBaseDelay          xtime.Duration
+	RetryMaxDelay           xtime.Duration
+	MaxBundleSize           string
+	BundleVerification      string
+	BundlePublicKeys        string
 }
 
 func main() {
@@ -41,15 +107,35 @@ func main() {
 
 // setup initializes and resets dependencies.
 func (p *Plugin) setup() {
-	notifications := make(chan updater.Notification)
 	// no need to provide a marketplace instance here since it'll be done by OnConfigurationChange(),
 	// and its called everytime when the configs are updated and at the first start time of the plugin.
 	// we only do the initialization here with the constant configs.
 	p.updater = updater.New(p.MattermostPlugin.API, nil, p.MattermostPlugin.API, []updater.Option{
-		updater.NotificationsOption(notifications),
 		updater.SkipPluginsOption([]string{manifest.ID}),
 	}...)
-	p.notifier = notifier.New(p.MattermostPlugin.API, notifications)
+	// the updater is never blocked by a slow notifier, notifications are dropped instead when the
+	// buffer is full and reported by the status command.
+	p.notifications = p.updater.SubscribeNotifications(notificationsBuffer, updater.DropPolicy)
+	p.notifier = notifier.New(p.MattermostPlugin.API, p.notifications.C)
+	p.events = p.updater.Subscribe(eventsBuffer)
+	go p.logEvents(p.events.C)
+}
+
+// logEvents logs the lifecycle events of the updater for debugging until events is closed.
+func (p *Plugin) logEvents(events <-chan updater.Event) {
+	for event := range events {
+		keyValues := []interface{}{"type", event.Type, "check_id", event.CheckID}
+		if event.PluginID != "" {
+			keyValues = append(keyValues, "plugin_id", event.PluginID, "version", event.Version)
+		}
+		if event.Reason != "" {
+			keyValues = append(keyValues, "reason", event.Reason)
+		}
+		if event.Err != nil {
+			keyValues = append(keyValues, "error", event.Err.Error())
+		}
+		p.API.LogDebug("updater event", keyValues...)
+	}
 }
 
 // start starts dependencies.
@@ -68,6 +154,12 @@ func (p *Plugin) stop(gracefullyWait bool) {
 		if err := p.updater.StopWait(); err != nil {
 			p.logError(err)
 		}
+		if dropped := p.notifications.Dropped(); dropped > 0 {
+			p.logInfo(fmt.Sprintf("%d notifications are dropped since the notifier could not keep up", dropped))
+		}
+		if dropped := p.events.Dropped(); dropped > 0 {
+			p.logInfo(fmt.Sprintf("%d events are dropped since they could not be logged in time", dropped))
+		}
 		p.logInfo("gracefully stopped")
 		return
 	}
@@ -86,28 +178,215 @@ func (p *Plugin) OnConfigurationChange() error {
 		p.setup()
 		p.initialized = true
 	}
-	p.updateConfig(conf)
-	return nil
+	return p.updateConfig(conf)
 }
 
 // updateConfig updates dependencies' configurations.
-func (p *Plugin) updateConfig(conf configuration) {
-	marketplace := marketplace.New(conf.MarketplaceAPIAddress)
+func (p *Plugin) updateConfig(conf configuration) error {
+	policy, err := updater.ParseUpdatePolicy(conf.UpdatePolicy)
+	if err != nil {
+		return err
+	}
+	pluginPolicies, err := updater.ParsePluginUpdatePolicies(conf.PluginUpdatePolicies)
+	if err != nil {
+		return err
+	}
+	channel, err := updater.ParseReleaseChannel(conf.ReleaseChannel)
+	if err != nil {
+		return err
+	}
+	pluginChannels, err := updater.ParsePluginReleaseChannels(conf.PluginReleaseChannels)
+	if err != nil {
+		return err
+	}
+	if conf.MinReleaseAge < 0 {
+		return fmt.Errorf("invalid min release age %q, it should not be negative", time.Duration(conf.MinReleaseAge))
+	}
+	holds, err := updater.ParseHolds(conf.PluginHolds)
+	if err != nil {
+		return err
+	}
+	approvalMode, err := updater.ParseApprovalMode(conf.ApprovalMode)
+	if err != nil {
+		return err
+	}
+	maintenanceWindows, err := xtime.ParseWindows(conf.MaintenanceWindows)
+	if err != nil {
+		return err
+	}
+	concurrency, err := parsePositiveInt("update concurrency", conf.UpdateConcurrency)
+	if err != nil {
+		return err
+	}
+	retryAttempts, err := parsePositiveInt("retry attempts", conf.RetryAttempts)
+	if err != nil {
+		return err
+	}
+	headers, err := xhttp.ParseHeaders(conf.HTTPHeaders)
+	if err != nil {
+		return err
+	}
+	headerHosts, err := marketplaceHosts(conf)
+	if err != nil {
+		return err
+	}
+	// the same transport is shared by the Marketplace and the updater to reuse connections.
+	transport, err := xhttp.NewTransport(xhttp.TransportConfig{
+		ProxyURL:          conf.HTTPProxy,
+		CACertificates:    conf.HTTPCACertificates,
+		ClientCertificate: conf.HTTPClientCertificate,
+		ClientKey:         conf.HTTPClientKey,
+		Headers:           headers,
+		HeaderHosts:       headerHosts,
+		ConnectTimeout:    time.Duration(conf.HTTPConnectTimeout),
+	})
+	if err != nil {
+		return err
+	}
+	marketplace, err := newMarketplace(conf, transport, p.API.GetServerVersion())
+	if err != nil {
+		return err
+	}
+	maxBundleSize, err := parsePositiveInt("max bundle size", conf.MaxBundleSize)
+	if err != nil {
+		return err
+	}
+	verificationMode, err := updater.ParseVerificationMode(conf.BundleVerification)
+	if err != nil {
+		return err
+	}
+	publicKeys, err := updater.ParsePublicKeys(conf.BundlePublicKeys)
+	if err != nil {
+		return err
+	}
 	p.updater.UpdateConfig([]updater.Option{
 		updater.MarketplaceOption(marketplace),
+		updater.TransportOption(transport),
 		updater.UpdateIntervalOption(time.Duration(conf.UpdateCheckFrequency)),
+		updater.UpdatePolicyOption(policy, pluginPolicies),
+		updater.ReleaseChannelOption(channel, pluginChannels),
+		updater.HoldsOption(holds),
+		updater.ApprovalOption(approvalMode, xstrings.SplitList(conf.ApprovalPlugins)),
+		updater.MinReleaseAgeOption(time.Duration(conf.MinReleaseAge)),
+		updater.HealthCheckTimeoutOption(time.Duration(conf.HealthCheckTimeout)),
+		updater.DryRunOption(conf.DryRun),
+		updater.MaintenanceWindowsOption(maintenanceWindows),
+		updater.ConcurrencyOption(concurrency),
+		updater.UpdateTimeoutOption(time.Duration(conf.UpdateTimeout)),
+		updater.RetryOption(updater.RetryPolicy{
+			Attempts:  retryAttempts,
+			BaseDelay: time.Duration(conf.RetryBaseDelay),
+			MaxDelay:  time.Duration(conf.RetryMaxDelay),
+			Jitter:    retryJitter,
+		}),
+		updater.MaxBundleSizeOption(int64(maxBundleSize) << 20),
+		updater.VerificationOption(verificationMode, publicKeys),
 	}...)
 	p.notifier.UpdateConfig([]notifier.Option{
 		notifier.NotificationChannelNameOption(conf.NotificationChannelName),
+		notifier.AdminsOption(notifier.AdminsMode(conf.AdminNotifications), xstrings.SplitList(conf.AdminUsernames)),
+		notifier.ApprovalURLOption(approvalURL()),
 	}...)
+	// connections of the replaced transport are not reused anymore.
+	if p.transport != nil {
+		xhttp.CloseIdleConnections(p.transport)
+	}
+	p.transport = transport
+	return nil
+}
+
+// marketplaceHosts returns the hosts of the Marketplace APIs in conf. headers without a host
+// are only sent to them.
+func marketplaceHosts(conf configuration) ([]string, error) {
+	_, addrs, err := marketplace.ParseSources(conf.MarketplaceAPIAddress)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		if marketplace.IsLocal(addr) {
+			continue
+		}
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid Marketplace address %q", addr)
+		}
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts, nil
+}
+
+// newMarketplace creates a Marketplace from a comma separated list of sources in conf ordered by
+// priority. each source is either a Marketplace API address or a local directory of plugin bundles.
+// plugins can be pinned to sources. Marketplace APIs are requested with transport and only list
+// plugins that are compatible with serverVersion.
+func newMarketplace(conf configuration, transport http.RoundTripper, serverVersion string) (updater.Marketplace, error) {
+	names, sourceAddrs, err := marketplace.ParseSources(conf.MarketplaceAPIAddress)
+	if err != nil {
+		return nil, err
+	}
+	pins, err := marketplace.ParsePins(conf.MarketplacePins)
+	if err != nil {
+		return nil, err
+	}
+	var sources []marketplace.Source
+	for i, addr := range sourceAddrs {
+		var lister marketplace.Lister = marketplace.New(addr, []marketplace.Option{
+			marketplace.ServerVersionOption(serverVersion),
+			marketplace.TransportOption(transport),
+			marketplace.TimeoutOption(time.Duration(conf.MarketplaceTimeout)),
+			marketplace.CacheOption(time.Duration(conf.MarketplaceCacheTTL), time.Duration(conf.MarketplaceStaleIfError)),
+		}...)
+		if marketplace.IsLocal(addr) {
+			lister = marketplace.NewLocal(addr)
+		}
+		sources = append(sources, marketplace.Source{Name: names[i], Lister: lister})
+	}
+	if len(sources) == 1 && len(pins) == 0 {
+		return sources[0].Lister, nil
+	}
+	return marketplace.NewComposite(sources, pins)
+}
+
+// parsePositiveInt parses setting with name from s as a positive number.
+// an empty s is parsed as zero to use the default value of the setting.
+func parsePositiveInt(name, s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, it should be a positive number", name, s)
+	}
+	return n, nil
 }
 
 // OnActivate starts the plugin.
 func (p *Plugin) OnActivate() error {
+	if err := p.ensureBot(); err != nil {
+		return err
+	}
+	if err := p.registerCommand(); err != nil {
+		return err
+	}
 	p.start()
 	return nil
 }
 
+// ensureBot creates the bot user if it does not exist yet and sets it to the notifier.
+func (p *Plugin) ensureBot() error {
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: botDisplayName,
+		Description: botDescription,
+	})
+	if err != nil {
+		return errors.Wrap(err, "cannot ensure the bot user")
+	}
+	p.notifier.UpdateConfig(notifier.BotUserIDOption(botUserID))
+	return nil
+}
+
 // OnDeactivate stops the plugin.
 func (p *Plugin) OnDeactivate() error {
 	p.initialized = false
@@ -0,0 +1,228 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
+	"github.com/pkg/errors"
+)
+
+const (
+	// approvalKeyPrefix is the KV store key prefix of the approvals of plugin updates.
+	approvalKeyPrefix = "marketplace-addon:approval:"
+)
+
+// ApprovalMode defines which plugins are only updated after an approval.
+type ApprovalMode string
+
+const (
+	// ApprovalOff updates plugins without approvals.
+	ApprovalOff ApprovalMode = "off"
+
+	// ApprovalAll requires an approval to update any plugin.
+	ApprovalAll ApprovalMode = "all"
+
+	// ApprovalSelected only requires an approval to update the listed plugins.
+	ApprovalSelected ApprovalMode = "selected"
+)
+
+// ParseApprovalMode parses an approval mode from s. an empty s is parsed as ApprovalOff.
+func ParseApprovalMode(s string) (ApprovalMode, error) {
+	switch mode := ApprovalMode(s); mode {
+	case "":
+		return ApprovalOff, nil
+	case ApprovalOff, ApprovalAll, ApprovalSelected:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown approval mode %q", s)
+}
+
+// ApprovalState is the state of an approval.
+type ApprovalState string
+
+const (
+	// ApprovalPending is the state of an approval that waits for a decision.
+	ApprovalPending ApprovalState = "pending"
+
+	// ApprovalApproved is the state of an approved update.
+	ApprovalApproved ApprovalState = "approved"
+
+	// ApprovalRejected is the state of a rejected update, it is not installed until a newer
+	// version supersedes it.
+	ApprovalRejected ApprovalState = "rejected"
+
+	// ApprovalSnoozed is the state of an update that is requested to be approved again later.
+	ApprovalSnoozed ApprovalState = "snoozed"
+)
+
+// Approval is an approval of updating a plugin to a version.
+type Approval struct {
+	// PluginID is the id of the plugin.
+	PluginID string `json:"plugin_id"`
+
+	// Version is the version of the plugin that is waiting for the approval.
+	Version string `json:"version"`
+
+	// State is the state of the approval.
+	State ApprovalState `json:"state"`
+
+	// RequestedAt is the time that the approval is requested at.
+	RequestedAt time.Time `json:"requested_at"`
+
+	// DecidedBy is the id of the user who approved, rejected or snoozed the update.
+	DecidedBy string `json:"decided_by,omitempty"`
+
+	// DecidedAt is the time of the decision.
+	DecidedAt time.Time `json:"decided_at"`
+
+	// SnoozedUntil is the time that a snoozed approval is requested again at.
+	SnoozedUntil time.Time `json:"snoozed_until"`
+}
+
+// requiresApproval checks if plugin with id is only updated after an approval.
+func (c config) requiresApproval(id string) bool {
+	switch c.approvalMode {
+	case ApprovalAll:
+		return true
+	case ApprovalSelected:
+		return xstrings.SliceContains(c.approvalPlugins, id)
+	}
+	return false
+}
+
+// awaitApproval checks if updateOp needs to wait for an approval with conf and requests one when
+// there is no approval for the next version yet. a new request supersedes the approval of an older
+// version. updateOp is marked with its approver once it is approved.
+func (u *Updater) awaitApproval(conf config, updateOp *UpdateOp) bool {
+	id := updateOp.installed.Id
+	if !conf.requiresApproval(id) {
+		return false
+	}
+	version := updateOp.next.Manifest.Version
+	approval, data, err := u.loadApproval(id)
+	if err != nil {
+		u.papi.LogError(err.Error())
+		return true
+	}
+	now := time.Now()
+	if approval != nil {
+		if approval.Version != version {
+			u.papi.LogInfo(fmt.Sprintf("approval of %q version of %q is expired, it is superseded by %q",
+				approval.Version, id, version))
+		} else {
+			switch approval.State {
+			case ApprovalApproved:
+				updateOp.approvedBy = approval.DecidedBy
+				return false
+			case ApprovalSnoozed:
+				if now.Before(approval.SnoozedUntil) {
+					return true
+				}
+			default:
+				return true
+			}
+		}
+	}
+	requested := Approval{PluginID: id, Version: version, State: ApprovalPending, RequestedAt: now.UTC()}
+	ok, err := u.saveApproval(requested, data)
+	if err != nil {
+		u.papi.LogError(err.Error())
+		return true
+	}
+	// another node might request the approval first.
+	if ok {
+		u.notifyApprovalRequested(updateOp)
+	}
+	return true
+}
+
+// Approval gets the approval of updating plugin with id. it returns nil when there is none.
+func (u *Updater) Approval(id string) (*Approval, error) {
+	approval, _, err := u.loadApproval(id)
+	return approval, err
+}
+
+// Approve approves updating plugin with id to version by user with userID.
+// the update is installed on the next update check.
+func (u *Updater) Approve(id, version, userID string) error {
+	return u.decide(id, version, userID, ApprovalApproved, time.Time{})
+}
+
+// Reject rejects updating plugin with id to version by user with userID.
+// the plugin is not updated until a newer version is requested to be approved.
+func (u *Updater) Reject(id, version, userID string) error {
+	return u.decide(id, version, userID, ApprovalRejected, time.Time{})
+}
+
+// Snooze snoozes the approval of updating plugin with id to version by user with userID for d.
+// the approval is requested again once it's over.
+func (u *Updater) Snooze(id, version, userID string, d time.Duration) error {
+	return u.decide(id, version, userID, ApprovalSnoozed, time.Now().UTC().Add(d))
+}
+
+// decide changes the state of the approval of updating plugin with id to version to state by
+// user with userID. ErrApprovalExpired is returned when the approval is superseded by a newer
+// version and *ApprovalDecidedError is returned when it is already approved or rejected.
+func (u *Updater) decide(id, version, userID string, state ApprovalState, snoozedUntil time.Time) error {
+	approval, data, err := u.loadApproval(id)
+	if err != nil {
+		return err
+	}
+	if approval == nil || approval.Version != version {
+		return ErrApprovalExpired
+	}
+	if approval.State == ApprovalApproved || approval.State == ApprovalRejected {
+		return &ApprovalDecidedError{Approval: *approval}
+	}
+	approval.State = state
+	approval.DecidedBy = userID
+	approval.DecidedAt = time.Now().UTC()
+	approval.SnoozedUntil = snoozedUntil
+	ok, err := u.saveApproval(*approval, data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("approval is modified concurrently, try again")
+	}
+	return nil
+}
+
+// deleteApproval deletes the approval of plugin with id once its update is installed.
+func (u *Updater) deleteApproval(id string) {
+	if aerr := u.papi.KVDelete(approvalKeyPrefix + id); aerr != nil {
+		u.papi.LogError(errors.Wrapf(aerr, "cannot delete the approval of %q", id).Error())
+	}
+}
+
+// saveApproval saves approval if the current data of the approval in the KV store is still
+// oldData. it reports if the approval is saved.
+func (u *Updater) saveApproval(approval Approval, oldData []byte) (bool, error) {
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return false, err
+	}
+	ok, aerr := u.papi.KVCompareAndSet(approvalKeyPrefix+approval.PluginID, oldData, data)
+	if aerr != nil {
+		return false, errors.Wrapf(aerr, "cannot save the approval of %q", approval.PluginID)
+	}
+	return ok, nil
+}
+
+// loadApproval loads the approval of plugin with id with its raw data.
+func (u *Updater) loadApproval(id string) (*Approval, []byte, error) {
+	data, aerr := u.papi.KVGet(approvalKeyPrefix + id)
+	if aerr != nil {
+		return nil, nil, errors.Wrapf(aerr, "cannot get the approval of %q", id)
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var approval Approval
+	if err := json.Unmarshal(data, &approval); err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot decode the approval of %q", id)
+	}
+	return &approval, data, nil
+}
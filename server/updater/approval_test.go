@@ -0,0 +1,110 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApproval(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buildBundle(t, "topdf", "1.3.0"))
+	}))
+	defer ts.Close()
+
+	var approvalData []byte
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	history := mockHistory(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateNotRunning}, nil)
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+	apiMock.On("KVGet", approvalKeyPrefix+"topdf").Return(func(string) []byte { return approvalData }, nil)
+	apiMock.On("KVCompareAndSet", approvalKeyPrefix+"topdf", mock.Anything, mock.Anything).Return(
+		func(key string, oldData, newData []byte) bool {
+			if string(oldData) != string(approvalData) {
+				return false
+			}
+			approvalData = newData
+			return true
+		}, nil)
+	apiMock.On("KVDelete", approvalKeyPrefix+"topdf").Once().Return(nil).Run(func(mock.Arguments) {
+		approvalData = nil
+	})
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+			DownloadURL: ts.URL,
+			Manifest:    &model.Manifest{Id: "topdf", Name: "TOPDF", Version: "1.3.0"},
+		}},
+	}, nil)
+
+	notifications := make(chan Notification, 10)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		ApprovalOption(ApprovalSelected, []string{"topdf"}),
+	}...)
+
+	// an approval of an older version is superseded by the new version.
+	ok, err := updater.saveApproval(Approval{PluginID: "topdf", Version: "1.2.5", State: ApprovalApproved}, nil)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.Empty(t, updater.discover(&round{}))
+	require.Len(t, notifications, 1)
+	notification := <-notifications
+	require.Equal(t, "1.3.0", notification.ApprovalRequested.UpdatedVersion)
+	require.Equal(t, ErrApprovalExpired, updater.Approve("topdf", "1.2.5", "admin"))
+
+	// the approval is only requested once.
+	require.Empty(t, updater.discover(&round{}))
+	require.Len(t, notifications, 0)
+
+	// snoozed approvals are requested again once the snooze is over.
+	require.NoError(t, updater.Snooze("topdf", "1.3.0", "admin", -time.Minute))
+	require.Empty(t, updater.discover(&round{}))
+	require.Len(t, notifications, 1)
+	<-notifications
+
+	require.NoError(t, updater.Approve("topdf", "1.3.0", "admin"))
+	err = updater.Reject("topdf", "1.3.0", "other-admin")
+	require.IsType(t, &ApprovalDecidedError{}, err)
+	require.Equal(t, `updating "topdf" to "1.3.0" version is already approved`, err.Error())
+
+	updates := updater.discover(&round{})
+	require.Len(t, updates, 1)
+	updater.update(updates[0])
+	notification = <-notifications
+	require.NoError(t, notification.Error)
+	require.NotNil(t, notification.Updated)
+	require.Equal(t, "admin", (*history)[0].ApprovedBy)
+	approval, err := updater.Approval("topdf")
+	require.NoError(t, err)
+	require.Nil(t, approval)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestParseApprovalMode(t *testing.T) {
+	mode, err := ParseApprovalMode("")
+	require.NoError(t, err)
+	require.Equal(t, ApprovalOff, mode)
+	mode, err = ParseApprovalMode("selected")
+	require.NoError(t, err)
+	require.Equal(t, ApprovalSelected, mode)
+	_, err = ParseApprovalMode("some")
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
+)
+
+// ReleaseChannel restricts updates by the stability of the new version.
+type ReleaseChannel string
+
+const (
+	// ChannelStable only allows final releases, e.g. 2.0.0.
+	ChannelStable ReleaseChannel = "stable"
+
+	// ChannelRC allows release candidates and final releases, e.g. 2.0.0-rc1.
+	ChannelRC ReleaseChannel = "rc"
+
+	// ChannelBeta allows all prereleases including release candidates and final releases,
+	// e.g. 2.0.0-beta1. prereleases that are not release candidates are considered as betas.
+	ChannelBeta ReleaseChannel = "beta"
+)
+
+// rank returns an order of channel from the most stable to the least stable.
+func (c ReleaseChannel) rank() int {
+	switch c {
+	case ChannelStable:
+		return 0
+	case ChannelRC:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// allows checks if c allows updating to version.
+func (c ReleaseChannel) allows(version semver.Version) bool {
+	return c.rank() >= releaseChannel(version).rank()
+}
+
+// validate checks if c is a known channel.
+func (c ReleaseChannel) validate() error {
+	switch c {
+	case ChannelStable, ChannelRC, ChannelBeta:
+		return nil
+	}
+	return fmt.Errorf("unknown release channel %q", c)
+}
+
+// releaseChannel returns the most stable channel that version is released in.
+func releaseChannel(version semver.Version) ReleaseChannel {
+	if len(version.Pre) == 0 {
+		return ChannelStable
+	}
+	first := version.Pre[0]
+	if !first.IsNum && strings.HasPrefix(strings.ToLower(first.VersionStr), "rc") {
+		return ChannelRC
+	}
+	return ChannelBeta
+}
+
+// ParseReleaseChannel parses a channel from s. an empty s is parsed as ChannelStable.
+func ParseReleaseChannel(s string) (ReleaseChannel, error) {
+	if s == "" {
+		return ChannelStable, nil
+	}
+	channel := ReleaseChannel(s)
+	return channel, channel.validate()
+}
+
+// ParsePluginReleaseChannels parses per plugin channels from s in the form of
+// "github:beta, jira:rc".
+func ParsePluginReleaseChannels(s string) (map[string]ReleaseChannel, error) {
+	channels := make(map[string]ReleaseChannel)
+	for _, entry := range xstrings.SplitList(s) {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid plugin release channel %q, it should be in the form of <plugin-id>:<channel>", entry)
+		}
+		id := strings.TrimSpace(parts[0])
+		channel := ReleaseChannel(strings.TrimSpace(parts[1]))
+		if err := channel.validate(); err != nil {
+			return nil, err
+		}
+		channels[id] = channel
+	}
+	return channels, nil
+}
+
+// latestRelease returns the latest version of plugin with id in plugins that is allowed by allows,
+// e.g. by a release channel. ErrNoNewerVersion is returned when the plugin only has versions that
+// are not allowed. a version that cannot be parsed is only returned when there are no other versions
+// so it can be reported by NewUpdateOp.
+func latestRelease(plugins marketplace.Plugins, id string, allows func(semver.Version) bool) (*marketplace.Plugin, error) {
+	var latest, invalid *marketplace.Plugin
+	var latestSemver semver.Version
+	found := false
+	for _, plugin := range plugins {
+		if plugin.Manifest.Id != id {
+			continue
+		}
+		found = true
+		version, err := semver.Parse(plugin.Manifest.Version)
+		if err != nil {
+			invalid = plugin
+			continue
+		}
+		if !allows(version) {
+			continue
+		}
+		if latest == nil || version.GT(latestSemver) {
+			latest, latestSemver = plugin, version
+		}
+	}
+	switch {
+	case latest != nil:
+		return latest, nil
+	case invalid != nil:
+		return invalid, nil
+	case found:
+		return nil, ErrNoNewerVersion
+	}
+	return nil, &marketplace.NotFoundError{ID: id}
+}
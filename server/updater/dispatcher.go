@@ -0,0 +1,207 @@
+package updater
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SubscribeNotifications subscribes to the notifications with a buffer of size buffer. policy
+// defines what happens to a notification when the buffer is full.
+func (u *Updater) SubscribeNotifications(buffer int, policy DeliveryPolicy) *NotificationSubscription {
+	return subscribeNotifications(u.notifications, make(chan Notification, buffer), policy)
+}
+
+// DeliveryPolicy defines what happens to a notification when the buffer of a subscriber is full.
+type DeliveryPolicy string
+
+const (
+	// DropPolicy drops the notification so the updater is never blocked by the subscriber.
+	DropPolicy DeliveryPolicy = "drop"
+
+	// BlockPolicy blocks the updater until the subscriber receives the notification. the
+	// notification is dropped instead once Updater is stopping, so a stalled subscriber cannot
+	// prevent the updater from stopping.
+	BlockPolicy DeliveryPolicy = "block"
+)
+
+// NotificationSubscription receives notifications from Updater.
+type NotificationSubscription struct {
+	// C receives the notifications. it is closed once Updater is stopped or the subscription
+	// is cancelled.
+	C <-chan Notification
+
+	*subscription
+}
+
+// subscribeNotifications subscribes notifications chan to d with policy.
+func subscribeNotifications(d *dispatcher, notifications chan Notification, policy DeliveryPolicy) *NotificationSubscription {
+	deliver := func(value interface{}, wait bool, done, released <-chan struct{}) bool {
+		notification := value.(Notification)
+		if !wait {
+			select {
+			case notifications <- notification:
+				return true
+			default:
+				return false
+			}
+		}
+		select {
+		case notifications <- notification:
+			return true
+		case <-done:
+		case <-released:
+		}
+		return false
+	}
+	return &NotificationSubscription{
+		C:            notifications,
+		subscription: d.subscribe(policy, deliver, func() { close(notifications) }),
+	}
+}
+
+// subscription is a subscriber of a dispatcher. the chan of the subscriber is only accessed by
+// deliver and closeChan since its element type depends on the values that are dispatched.
+type subscription struct {
+	// dropped is the number of dropped values. it is accessed atomically.
+	dropped uint64
+
+	// policy defines what happens to a value when the chan of the subscriber is full.
+	policy DeliveryPolicy
+
+	// deliver sends value to the chan of the subscriber and reports whether it is sent. it waits
+	// until done or released is closed when wait is set, otherwise it gives up right away when
+	// the chan is full.
+	deliver func(value interface{}, wait bool, done, released <-chan struct{}) bool
+
+	// closeChan closes the chan of the subscriber.
+	closeChan func()
+
+	// dispatcher is the dispatcher that the subscription belongs to.
+	dispatcher *dispatcher
+
+	m sync.RWMutex // protects closed and the chan from being closed while sending.
+	// closed is set once the chan is closed.
+	closed bool
+
+	// done is closed to release senders that are blocked on the chan before closing it.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Dropped returns the number of values dropped for the subscriber.
+func (s *subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Cancel cancels the subscription and closes its chan. calling Cancel multiple times has no effects.
+func (s *subscription) Cancel() {
+	s.dispatcher.remove(s)
+	s.close()
+}
+
+// send sends value to the subscriber by the delivery policy. the value is dropped if it cannot
+// be delivered before released is closed.
+func (s *subscription) send(value interface{}, released <-chan struct{}) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.closed {
+		return
+	}
+	if !s.deliver(value, s.policy == BlockPolicy, s.done, released) {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// close closes the chan after releasing the senders that are blocked on it.
+func (s *subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.m.Lock()
+		defer s.m.Unlock()
+		s.closed = true
+		s.closeChan()
+	})
+}
+
+// dispatcher fans out values to subscribers with bounded buffers.
+type dispatcher struct {
+	m sync.Mutex // protects subscriptions and closed.
+	// subscriptions are the active subscriptions.
+	subscriptions map[*subscription]struct{}
+	// closed is set once the dispatcher is closed.
+	closed bool
+
+	// released is closed when the updater is stopping to stop blocking on subscribers.
+	released    chan struct{}
+	releaseOnce sync.Once
+}
+
+// newDispatcher creates a new dispatcher.
+func newDispatcher() *dispatcher {
+	return &dispatcher{
+		subscriptions: make(map[*subscription]struct{}),
+		released:      make(chan struct{}),
+	}
+}
+
+// subscribe subscribes a chan that is accessed by deliver and closeChan with policy.
+func (d *dispatcher) subscribe(policy DeliveryPolicy,
+	deliver func(value interface{}, wait bool, done, released <-chan struct{}) bool,
+	closeChan func()) *subscription {
+	s := &subscription{
+		policy:     policy,
+		deliver:    deliver,
+		closeChan:  closeChan,
+		dispatcher: d,
+		done:       make(chan struct{}),
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.closed {
+		s.close()
+		return s
+	}
+	d.subscriptions[s] = struct{}{}
+	return s
+}
+
+// remove removes subscription s.
+func (d *dispatcher) remove(s *subscription) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	delete(d.subscriptions, s)
+}
+
+// publish sends value to the subscribers one by one.
+func (d *dispatcher) publish(value interface{}) {
+	d.m.Lock()
+	subscriptions := make([]*subscription, 0, len(d.subscriptions))
+	for s := range d.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	d.m.Unlock()
+	for _, s := range subscriptions {
+		s.send(value, d.released)
+	}
+}
+
+// release stops blocking on subscribers with BlockPolicy, values that cannot be delivered right
+// away are dropped from now on.
+func (d *dispatcher) release() {
+	d.releaseOnce.Do(func() {
+		close(d.released)
+	})
+}
+
+// close closes all subscriptions, new subscriptions are closed right away.
+func (d *dispatcher) close() {
+	d.release()
+	d.m.Lock()
+	subscriptions := d.subscriptions
+	d.subscriptions = make(map[*subscription]struct{})
+	d.closed = true
+	d.m.Unlock()
+	for s := range subscriptions {
+		s.close()
+	}
+}
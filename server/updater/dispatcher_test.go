@@ -0,0 +1,129 @@
+package updater
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcherFanOut(t *testing.T) {
+	const publishers, perPublisher = 8, 50
+
+	d := newDispatcher()
+	blocking := subscribeNotifications(d, make(chan Notification, 4), BlockPolicy)
+	dropping := subscribeNotifications(d, make(chan Notification, 4), DropPolicy)
+
+	// the blocking subscriber consumes every notification.
+	var received int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range blocking.C {
+			received++
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(publishers)
+	for i := 0; i < publishers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				d.publish(Notification{PluginID: fmt.Sprintf("plugin%d", i)})
+			}
+		}(i)
+	}
+	wg.Wait()
+	d.close()
+	<-done
+
+	var buffered int
+	for range dropping.C {
+		buffered++
+	}
+	require.Equal(t, publishers*perPublisher, received)
+	require.Zero(t, blocking.Dropped())
+	require.Equal(t, 4, buffered)
+	require.Equal(t, uint64(publishers*perPublisher-4), dropping.Dropped())
+}
+
+func TestDispatcherRelease(t *testing.T) {
+	d := newDispatcher()
+	stalled := subscribeNotifications(d, make(chan Notification), BlockPolicy)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		d.publish(Notification{PluginID: "topdf"})
+	}()
+	select {
+	case <-published:
+		t.Fatal("publish should block until the notification is received")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// releasing the dispatcher drops the notifications of stalled subscribers.
+	d.release()
+	<-published
+	require.Equal(t, uint64(1), stalled.Dropped())
+	d.publish(Notification{PluginID: "topdf"})
+	require.Equal(t, uint64(2), stalled.Dropped())
+}
+
+func TestDispatcherCancel(t *testing.T) {
+	d := newDispatcher()
+	subscription := subscribeNotifications(d, make(chan Notification), BlockPolicy)
+	other := subscribeNotifications(d, make(chan Notification, 1), BlockPolicy)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		d.publish(Notification{PluginID: "topdf"})
+	}()
+	// cancelling a subscription releases the publisher that is blocked on it.
+	time.Sleep(10 * time.Millisecond)
+	subscription.Cancel()
+	subscription.Cancel()
+	<-published
+	_, ok := <-subscription.C
+	require.False(t, ok)
+	require.Equal(t, "topdf", (<-other.C).PluginID)
+
+	d.publish(Notification{PluginID: "jira"})
+	require.Equal(t, "jira", (<-other.C).PluginID)
+
+	d.close()
+	_, ok = <-other.C
+	require.False(t, ok)
+
+	// subscribing to a closed dispatcher returns a closed subscription.
+	closed := subscribeNotifications(d, make(chan Notification, 1), DropPolicy)
+	_, ok = <-closed.C
+	require.False(t, ok)
+}
+
+func TestStopWithStalledSubscriber(t *testing.T) {
+	updater := New(nil, nil, dlocktest.NewStore())
+	stalled := updater.SubscribeNotifications(0, BlockPolicy)
+	require.NoError(t, updater.goJob(func() {
+		updater.notifyError("topdf", ErrNoNewerVersion)
+	}))
+	require.NoError(t, updater.Stop())
+
+	// jobs are not blocked by a stalled subscriber once Updater is stopping.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		updater.jobs.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs should not hang on a stalled subscriber")
+	}
+	require.Equal(t, uint64(1), stalled.Dropped())
+}
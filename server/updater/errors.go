@@ -3,6 +3,7 @@ package updater
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
@@ -14,8 +15,43 @@ var (
 
 	// ErrDifferentPlugins error is returned when two plugins are not the same by their id.
 	ErrDifferentPlugins = errors.New("plugins are not the same, it cannot be updated")
+
+	// ErrUpdateInProgress error is returned when plugin is already being updated.
+	ErrUpdateInProgress = errors.New("plugin is already being updated")
+
+	// ErrPluginNotInstalled error is returned when plugin is not installed.
+	ErrPluginNotInstalled = errors.New("plugin is not installed")
+
+	// ErrStopped error is returned when a job is requested after Updater is stopped.
+	ErrStopped = errors.New("updater is stopped")
+
+	// ErrDryRun error is returned when an update is requested in dry-run mode.
+	ErrDryRun = errors.New("updates are not installed in dry-run mode")
+
+	// ErrHoldNotFound error is returned when a plugin has no hold to remove.
+	ErrHoldNotFound = errors.New("plugin is not held")
+
+	// ErrApprovalExpired error is returned when an approval is decided after it is superseded by
+	// a newer version.
+	ErrApprovalExpired = errors.New("approval is expired, a newer version supersedes it")
 )
 
+// VersionError is returned when a plugin's version is not a valid semver.
+type VersionError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// Version is the invalid version.
+	Version string
+
+	// Err is the parsing error.
+	Err error
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%q version of %q plugin is not a valid semver: %s", e.Version, e.PluginID, e.Err)
+}
+
 // ServerVersionError is returned when new version of a plugin is not compatible
 // with the current version of Mattermost Server.
 type ServerVersionError struct {
@@ -39,3 +75,193 @@ func (e *ServerVersionError) Error() string {
 	return fmt.Sprintf("min required server version is %q to install %q version of %q plugin but server has a lower version %q",
 		e.RequiredServerVersion, e.NextPluginVersion, e.PluginID, e.CurrentServerVersion)
 }
+
+// UpdatePolicyError is returned when a newer version of a plugin is available but its
+// update policy does not allow updating to it.
+type UpdatePolicyError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// CurrentPluginVersion is the currently installed version of the plugin.
+	CurrentPluginVersion string
+
+	// NextPluginVersion is the newest version of the plugin that is available.
+	NextPluginVersion string
+
+	// Policy is the update policy of the plugin.
+	Policy UpdatePolicy
+
+	// RequiredPolicy is the policy needed to update to the newest version.
+	RequiredPolicy UpdatePolicy
+}
+
+func (e *UpdatePolicyError) Error() string {
+	return fmt.Sprintf("%q version of %q plugin is a %s update from %q but the update policy only allows %s updates",
+		e.NextPluginVersion, e.PluginID, e.RequiredPolicy, e.CurrentPluginVersion, e.Policy)
+}
+
+// RollbackError is returned when an updated plugin fails the health checks and it is
+// rolled back to its previously installed version.
+type RollbackError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// FailedPluginVersion is the version of the plugin that failed the health checks.
+	FailedPluginVersion string
+
+	// RestoredPluginVersion is the version of the plugin that is installed back.
+	RestoredPluginVersion string
+
+	// Err is the reason of the rollback.
+	Err error
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%q plugin is rolled back from %q to %q: %s",
+		e.PluginID, e.FailedPluginVersion, e.RestoredPluginVersion, e.Err)
+}
+
+// BackupError is notified when the installed version of a plugin cannot be backed up before
+// updating it, so the update cannot be rolled back if the new version fails the health checks.
+type BackupError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the newest version of the plugin that is installed without a backup.
+	NextPluginVersion string
+
+	// Err is the reason why the plugin cannot be backed up.
+	Err error
+}
+
+func (e *BackupError) Error() string {
+	return fmt.Sprintf("%q plugin cannot be backed up, its update to %q version cannot be rolled back: %s",
+		e.PluginID, e.NextPluginVersion, e.Err)
+}
+
+// TimeoutError is returned when an update cannot be completed in time.
+type TimeoutError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the newest version of the plugin that we tried to install.
+	NextPluginVersion string
+
+	// Timeout is the max time that an update can take.
+	Timeout time.Duration
+
+	// Err is the error caused by the timeout.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("updating %q plugin to %q version is timed out after %s: %s",
+		e.PluginID, e.NextPluginVersion, e.Timeout, e.Err)
+}
+
+// Cause returns the error caused by the timeout.
+func (e *TimeoutError) Cause() error {
+	return e.Err
+}
+
+// VerificationError is returned when a downloaded bundle cannot be verified against its published
+// signature or digest.
+type VerificationError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the newest version of the plugin that we tried to install.
+	NextPluginVersion string
+
+	// Reason explains why the bundle is rejected.
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("bundle of %q version of %q plugin is rejected: %s", e.NextPluginVersion, e.PluginID, e.Reason)
+}
+
+// ReleaseChannelError is returned when the next version of a plugin is not released in the
+// release channel of the plugin.
+type ReleaseChannelError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the prerelease version of the plugin.
+	NextPluginVersion string
+
+	// Channel is the release channel of the plugin.
+	Channel ReleaseChannel
+}
+
+func (e *ReleaseChannelError) Error() string {
+	return fmt.Sprintf("%q version of %q plugin is not released in the %s channel", e.NextPluginVersion, e.PluginID, e.Channel)
+}
+
+// SoakError is returned when the next version of a plugin is released more recently than the
+// min release age.
+type SoakError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the newest version of the plugin that is available.
+	NextPluginVersion string
+
+	// ReleasedAt is the time that the next version is released at or first seen by the updater.
+	ReleasedAt time.Time
+
+	// Until is the time that the next version can be installed after.
+	Until time.Time
+}
+
+func (e *SoakError) Error() string {
+	return fmt.Sprintf("%q version of %q plugin is pending soak until %s",
+		e.NextPluginVersion, e.PluginID, e.Until.UTC().Format(time.RFC3339))
+}
+
+// HoldError is returned when the next version of a plugin is held.
+type HoldError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the newest version of the plugin that is available.
+	NextPluginVersion string
+
+	// Hold is the hold of the plugin.
+	Hold Hold
+}
+
+func (e *HoldError) Error() string {
+	if e.Hold.Version != "" {
+		return fmt.Sprintf("%q version of %q plugin is not allowed, the plugin is %s",
+			e.NextPluginVersion, e.PluginID, e.Hold.Describe())
+	}
+	return fmt.Sprintf("%q version of %q plugin is %s", e.NextPluginVersion, e.PluginID, e.Hold.Describe())
+}
+
+// RolledBackVersionError is returned when the next version of a plugin is rolled back before
+// since it failed the health checks. the version is skipped until a newer version is released or
+// it is updated manually.
+type RolledBackVersionError struct {
+	// PluginID of the Plugin.
+	PluginID string
+
+	// NextPluginVersion is the rolled back version of the plugin.
+	NextPluginVersion string
+}
+
+func (e *RolledBackVersionError) Error() string {
+	return fmt.Sprintf("%q version of %q plugin is rolled back since it failed the health checks, it is skipped until a newer version is released",
+		e.NextPluginVersion, e.PluginID)
+}
+
+// ApprovalDecidedError is returned when an approval is decided after it is already approved or
+// rejected.
+type ApprovalDecidedError struct {
+	// Approval is the decided approval.
+	Approval Approval
+}
+
+func (e *ApprovalDecidedError) Error() string {
+	return fmt.Sprintf("updating %q to %q version is already %s", e.Approval.PluginID, e.Approval.Version, e.Approval.State)
+}
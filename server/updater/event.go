@@ -0,0 +1,210 @@
+package updater
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval is the min time between the download progress events of a bundle.
+	progressInterval = time.Second
+)
+
+// EventType is the type of a lifecycle event.
+type EventType string
+
+const (
+	// EventCheckStarted is emitted when a check round starts.
+	EventCheckStarted EventType = "check_started"
+
+	// EventCheckFinished is emitted when a check round finishes. Counts is filled with the
+	// results of the round and Err is set when plugins cannot be listed.
+	EventCheckFinished EventType = "check_finished"
+
+	// EventUpdateQueued is emitted when an update waits for the next maintenance window.
+	EventUpdateQueued EventType = "update_queued"
+
+	// EventDownloadStarted is emitted when downloading the bundle of an update starts.
+	// it is emitted again for each retry of a failed download.
+	EventDownloadStarted EventType = "download_started"
+
+	// EventDownloadProgress is emitted periodically while a bundle is downloaded.
+	EventDownloadProgress EventType = "download_progress"
+
+	// EventDownloadFinished is emitted when downloading a bundle finishes. Err is set when
+	// the download is failed.
+	EventDownloadFinished EventType = "download_finished"
+
+	// EventInstalled is emitted when an update is installed.
+	EventInstalled EventType = "installed"
+
+	// EventUpdateFailed is emitted when an update cannot be installed. Err is the reason.
+	EventUpdateFailed EventType = "update_failed"
+
+	// EventRolledBack is emitted when an update is rolled back to the previous version since
+	// the new version is not healthy. Err is the reason.
+	EventRolledBack EventType = "rolled_back"
+
+	// EventSkipped is emitted when a new version is not installed. Reason explains why.
+	EventSkipped EventType = "skipped"
+)
+
+// Event is a lifecycle event of Updater.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+
+	// CheckID correlates the events of the same check round or manual update.
+	CheckID string
+
+	// Time is the time of the event.
+	Time time.Time
+
+	// PluginID is the id of the plugin. it is empty for the events of check rounds.
+	PluginID string
+
+	// PreviousVersion is the installed version of the plugin.
+	PreviousVersion string
+
+	// Version is the new version of the plugin.
+	Version string
+
+	// BytesRead is the number of bytes downloaded so far.
+	BytesRead int64
+
+	// BytesTotal is the size of the downloaded bundle or -1 when it is unknown.
+	BytesTotal int64
+
+	// Counts is the results of a check round and only filled for EventCheckFinished.
+	Counts *CheckCounts
+
+	// Reason explains why a new version is skipped.
+	Reason string
+
+	// Err is the error of a failed step.
+	Err error
+}
+
+// CheckCounts is the results of a check round.
+type CheckCounts struct {
+	// Checked is the number of installed plugins that are checked for new versions.
+	Checked int
+
+	// Updates is the number of updates found.
+	Updates int
+
+	// Queued is the number of updates that wait for the next maintenance window.
+	Queued int
+
+	// Skipped is the number of new versions that are not installed.
+	Skipped int
+
+	// Installed is the number of installed updates.
+	Installed int
+
+	// Failed is the number of updates that cannot be installed.
+	Failed int
+}
+
+// round is a check round that correlates its events and counts its results.
+type round struct {
+	// id is the correlation id of the round.
+	id string
+
+	mc sync.Mutex // protects counts.
+	// counts keeps the results of the round.
+	counts CheckCounts
+
+	// err is the error of listing plugins.
+	err error
+}
+
+// count modifies the counts of r with fn.
+func (r *round) count(fn func(*CheckCounts)) {
+	r.mc.Lock()
+	defer r.mc.Unlock()
+	fn(&r.counts)
+}
+
+// finished creates the EventCheckFinished of r.
+func (r *round) finished() Event {
+	r.mc.Lock()
+	defer r.mc.Unlock()
+	counts := r.counts
+	return Event{Type: EventCheckFinished, CheckID: r.id, Counts: &counts, Err: r.err}
+}
+
+// Subscribe subscribes to the lifecycle events with a buffer of size buffer.
+// events are never blocked by slow subscribers, an event is dropped for a subscriber when its
+// buffer is full and counted by the subscription.
+func (u *Updater) Subscribe(buffer int) *EventSubscription {
+	return subscribeEvents(u.events, make(chan Event, buffer))
+}
+
+// EventSubscription receives lifecycle events from Updater.
+type EventSubscription struct {
+	// C receives the events. it is closed once Updater is stopped or the subscription is
+	// cancelled.
+	C <-chan Event
+
+	*subscription
+}
+
+// subscribeEvents subscribes events chan to d. events are dropped when the chan is full.
+func subscribeEvents(d *dispatcher, events chan Event) *EventSubscription {
+	deliver := func(value interface{}, wait bool, done, released <-chan struct{}) bool {
+		select {
+		case events <- value.(Event):
+			return true
+		default:
+			return false
+		}
+	}
+	return &EventSubscription{
+		C:            events,
+		subscription: d.subscribe(DropPolicy, deliver, func() { close(events) }),
+	}
+}
+
+// emit publishes event.
+func (u *Updater) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	u.events.publish(event)
+}
+
+// emitUpdate publishes an event of type t about updateOp.
+func (u *Updater) emitUpdate(t EventType, updateOp *UpdateOp, event Event) {
+	event.Type = t
+	event.CheckID = updateOp.checkID
+	event.PluginID = updateOp.installed.Id
+	event.PreviousVersion = updateOp.installed.Version
+	event.Version = updateOp.next.Manifest.Version
+	u.emit(event)
+}
+
+// skip publishes an EventSkipped about version of plugin with id with reason and counts it in r.
+func (u *Updater) skip(r *round, id, previousVersion, version, reason string) {
+	r.count(func(c *CheckCounts) { c.Skipped++ })
+	u.emit(Event{
+		Type:            EventSkipped,
+		CheckID:         r.id,
+		PluginID:        id,
+		PreviousVersion: previousVersion,
+		Version:         version,
+		Reason:          reason,
+	})
+}
+
+// downloadProgress returns a func that publishes download progress events about updateOp at most
+// once in progressInterval.
+func (u *Updater) downloadProgress(updateOp *UpdateOp) func(read, total int64) {
+	var last time.Time
+	return func(read, total int64) {
+		if now := time.Now(); now.Sub(last) >= progressInterval {
+			last = now
+			u.emitUpdate(EventDownloadProgress, updateOp, Event{BytesRead: read, BytesTotal: total})
+		}
+	}
+}
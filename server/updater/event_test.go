@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buildBundle(t, "topdf", "1.3.0"))
+	}))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	mockHistory(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "jira", Version: "2.3.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateNotRunning}, nil)
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+			DownloadURL: ts.URL,
+			Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+		}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "3.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+
+	notifications := make(chan Notification, 2)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		UpdatePolicyOption(PolicyMinor, nil),
+	}...)
+	events := updater.Subscribe(20)
+	updater.checkAndUpdate()
+	events.Cancel()
+
+	var types []EventType
+	var received []Event
+	for event := range events.C {
+		require.NotZero(t, event.Time)
+		if event.Type == EventDownloadProgress {
+			continue
+		}
+		types = append(types, event.Type)
+		received = append(received, event)
+	}
+	require.Equal(t, []EventType{
+		EventCheckStarted,
+		EventSkipped,
+		EventDownloadStarted,
+		EventDownloadFinished,
+		EventInstalled,
+		EventCheckFinished,
+	}, types)
+	checkID := received[0].CheckID
+	require.NotEmpty(t, checkID)
+	for _, event := range received {
+		require.Equal(t, checkID, event.CheckID)
+	}
+	require.Equal(t, "jira", received[1].PluginID)
+	require.Equal(t, "3.0.0", received[1].Version)
+	require.Equal(t, (&UpdatePolicyError{"jira", "2.3.0", "3.0.0", PolicyMinor, PolicyMajor}).Error(), received[1].Reason)
+	require.Equal(t, "topdf", received[4].PluginID)
+	require.Equal(t, "1.2.1", received[4].PreviousVersion)
+	require.Equal(t, "1.3.0", received[4].Version)
+	require.Equal(t, &CheckCounts{Checked: 3, Updates: 1, Skipped: 1, Installed: 1}, received[5].Counts)
+	require.Zero(t, events.Dropped())
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestEventSubscriptions(t *testing.T) {
+	d := newDispatcher()
+	slow := subscribeEvents(d, make(chan Event, 1))
+	fast := subscribeEvents(d, make(chan Event, 2))
+
+	// events are dropped for subscribers with full buffers.
+	d.publish(Event{Type: EventCheckStarted})
+	d.publish(Event{Type: EventCheckFinished})
+	require.Len(t, slow.C, 1)
+	require.Len(t, fast.C, 2)
+	require.Equal(t, uint64(1), slow.Dropped())
+	require.Zero(t, fast.Dropped())
+
+	fast.Cancel()
+	fast.Cancel()
+	d.publish(Event{Type: EventCheckStarted})
+	require.Len(t, fast.C, 2)
+
+	d.close()
+	require.Equal(t, EventCheckStarted, (<-slow.C).Type)
+	_, ok := <-slow.C
+	require.False(t, ok)
+
+	// subscribing to a closed dispatcher returns a closed subscription.
+	closed := subscribeEvents(d, make(chan Event, 1))
+	_, ok = <-closed.C
+	require.False(t, ok)
+}
@@ -0,0 +1,166 @@
+package updater
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// historyKey is the KV store key of the update history.
+	historyKey = "marketplace-addon:history"
+
+	// maxHistoryRecords is the max number of records kept in the history, older records are
+	// dropped first.
+	maxHistoryRecords = 1000
+
+	// maxHistoryWriteAttempts is the max number of attempts to save a record when the history
+	// is concurrently modified.
+	maxHistoryWriteAttempts = 10
+
+	// defaultHistoryPerPage is the page size used when it is not set in a HistoryQuery.
+	defaultHistoryPerPage = 20
+)
+
+// Outcome is the result of an update attempt.
+type Outcome string
+
+const (
+	// OutcomeUpdated is the outcome of a successful update.
+	OutcomeUpdated Outcome = "updated"
+
+	// OutcomeFailed is the outcome of a failed update.
+	OutcomeFailed Outcome = "failed"
+
+	// OutcomeRolledBack is the outcome of an update that is rolled back to the previous version.
+	OutcomeRolledBack Outcome = "rolled_back"
+)
+
+// HistoryRecord is a record of an update attempt.
+type HistoryRecord struct {
+	// PluginID is the id of the plugin.
+	PluginID string `json:"plugin_id"`
+
+	// FromVersion is the version of the plugin before the update.
+	FromVersion string `json:"from_version"`
+
+	// ToVersion is the version of the plugin that is tried to be installed.
+	ToVersion string `json:"to_version"`
+
+	// Time is the time of the update attempt.
+	Time time.Time `json:"time"`
+
+	// Outcome is the result of the update attempt.
+	Outcome Outcome `json:"outcome"`
+
+	// Error is the reason of a failed or rolled back update.
+	Error string `json:"error,omitempty"`
+
+	// ApprovedBy is the id of the user who approved the update, it is empty when the update
+	// did not require an approval.
+	ApprovedBy string `json:"approved_by,omitempty"`
+}
+
+// HistoryQuery filters and paginates the update history.
+type HistoryQuery struct {
+	// PluginID filters records by plugin id, all records are listed when it is empty.
+	PluginID string
+
+	// Page is the zero based page number.
+	Page int
+
+	// PerPage is the number of records per page, defaults to 20.
+	PerPage int
+}
+
+// History lists the recorded update attempts that match with query from newest to oldest.
+func (u *Updater) History(query HistoryQuery) ([]HistoryRecord, error) {
+	records, _, err := u.loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	if query.PerPage <= 0 {
+		query.PerPage = defaultHistoryPerPage
+	}
+	var matched []HistoryRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if query.PluginID == "" || records[i].PluginID == query.PluginID {
+			matched = append(matched, records[i])
+		}
+	}
+	start := query.Page * query.PerPage
+	if query.Page < 0 || start >= len(matched) {
+		return nil, nil
+	}
+	end := start + query.PerPage
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[start:end], nil
+}
+
+// record saves the update attempt made by updateOp with its result err to the history.
+func (u *Updater) record(updateOp *UpdateOp, err error) {
+	record := HistoryRecord{
+		PluginID:    updateOp.installed.Id,
+		FromVersion: updateOp.installed.Version,
+		ToVersion:   updateOp.next.Manifest.Version,
+		Time:        time.Now().UTC(),
+		Outcome:     OutcomeUpdated,
+		ApprovedBy:  updateOp.approvedBy,
+	}
+	if err != nil {
+		record.Outcome = OutcomeFailed
+		if _, ok := err.(*RollbackError); ok {
+			record.Outcome = OutcomeRolledBack
+		}
+		record.Error = err.Error()
+	}
+	if err := u.addHistoryRecord(record); err != nil {
+		u.papi.LogError(errors.Wrap(err, "cannot record the update to the history").Error())
+	}
+}
+
+// addHistoryRecord appends record to the history. it retries on concurrent modifications since
+// multiple updates can be recorded at the same time.
+func (u *Updater) addHistoryRecord(record HistoryRecord) error {
+	for i := 0; i < maxHistoryWriteAttempts; i++ {
+		records, data, err := u.loadHistory()
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+		if len(records) > maxHistoryRecords {
+			records = records[len(records)-maxHistoryRecords:]
+		}
+		newData, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		ok, aerr := u.papi.KVCompareAndSet(historyKey, data, newData)
+		if aerr != nil {
+			return aerr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("history is modified concurrently too many times")
+}
+
+// loadHistory loads all records in the history from oldest to newest with their raw data.
+func (u *Updater) loadHistory() ([]HistoryRecord, []byte, error) {
+	data, aerr := u.papi.KVGet(historyKey)
+	if aerr != nil {
+		return nil, nil, errors.Wrap(aerr, "cannot get the history")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var records []HistoryRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot decode the history")
+	}
+	return records, data, nil
+}
@@ -0,0 +1,261 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+)
+
+const (
+	// holdsKey is the KV store key of the holds that are set at runtime.
+	holdsKey = "marketplace-addon:holds"
+
+	// maxHoldsWriteAttempts is the max number of attempts to save the holds when they're
+	// concurrently modified.
+	maxHoldsWriteAttempts = 10
+
+	// holdDateLayout is the layout of the expiry dates of holds.
+	holdDateLayout = "2006-01-02"
+)
+
+// Hold holds updates of a plugin. a plugin is either held at all or pinned to a version range.
+type Hold struct {
+	// PluginID is the id of the held plugin.
+	PluginID string `json:"plugin_id"`
+
+	// Version is the semver range that the plugin is pinned to, e.g. "1.4.x" or ">=1.4.0 <2.0.0".
+	// all updates of the plugin are held when it is empty.
+	Version string `json:"version,omitempty"`
+
+	// Until is the time that the hold expires at. it never expires when it is zero.
+	Until time.Time `json:"until"`
+
+	// Reason explains why the plugin is held.
+	Reason string `json:"reason,omitempty"`
+}
+
+// active checks if h is not expired at now.
+func (h Hold) active(now time.Time) bool {
+	return h.Until.IsZero() || now.Before(h.Until)
+}
+
+// allows checks if h allows updating to version.
+func (h Hold) allows(version semver.Version) bool {
+	if h.Version == "" {
+		return false
+	}
+	versionRange, err := semver.ParseRange(h.Version)
+	return err == nil && versionRange(version)
+}
+
+// validate checks if h is a valid hold.
+func (h Hold) validate() error {
+	if h.PluginID == "" {
+		return errors.New("plugin id of the hold is missing")
+	}
+	if h.Version == "" {
+		return nil
+	}
+	if _, err := semver.ParseRange(h.Version); err != nil {
+		return errors.Wrapf(err, "invalid version range %q", h.Version)
+	}
+	return nil
+}
+
+// String formats h in the same form that ParseHold parses.
+func (h Hold) String() string {
+	s := h.PluginID
+	if h.Version != "" {
+		s += "@" + h.Version
+	}
+	if !h.Until.IsZero() {
+		s += " until " + h.Until.UTC().Format(holdDateLayout)
+	}
+	if h.Reason != "" {
+		s += ": " + h.Reason
+	}
+	return s
+}
+
+// Describe describes h in a human readable form, e.g. `pinned to "1.4.x" until 2026-12-01: waiting for vendor fix`.
+func (h Hold) Describe() string {
+	s := "held"
+	if h.Version != "" {
+		s = fmt.Sprintf("pinned to %q", h.Version)
+	}
+	if !h.Until.IsZero() {
+		s += " until " + h.Until.UTC().Format(holdDateLayout)
+	}
+	if h.Reason != "" {
+		s += ": " + h.Reason
+	}
+	return s
+}
+
+// ParseHold parses a hold from s in the form of "<plugin-id>[@<version-range>] [until <yyyy-mm-dd>][: <reason>]",
+// e.g. "jira@1.4.x: waiting for vendor fix" or "github until 2026-12-01".
+// a hold with an expiry date expires at the beginning of that day in UTC.
+func ParseHold(s string) (Hold, error) {
+	var hold Hold
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 {
+		hold.Reason = strings.TrimSpace(parts[1])
+	}
+	fields := strings.Fields(parts[0])
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 3 && fields[1] == "until":
+		until, err := time.Parse(holdDateLayout, fields[2])
+		if err != nil {
+			return Hold{}, fmt.Errorf("invalid hold expiry date %q, it should be in the form of yyyy-mm-dd", fields[2])
+		}
+		hold.Until = until
+	default:
+		return Hold{}, fmt.Errorf("invalid hold %q, it should be in the form of <plugin-id>[@<version-range>] [until <yyyy-mm-dd>][: <reason>]", s)
+	}
+	idVersion := strings.SplitN(fields[0], "@", 2)
+	hold.PluginID = idVersion[0]
+	if len(idVersion) == 2 {
+		hold.Version = idVersion[1]
+	}
+	return hold, hold.validate()
+}
+
+// ParseHolds parses holds from s with a hold per line. see ParseHold for the format of a hold.
+func ParseHolds(s string) ([]Hold, error) {
+	var holds []Hold
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		hold, err := ParseHold(line)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, nil
+}
+
+// Holds lists the active holds sorted by plugin id. holds set at runtime overwrite the ones set
+// by HoldsOption for the same plugins.
+func (u *Updater) Holds() ([]Hold, error) {
+	active, err := u.activeHolds(u.cloneConfing(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	holds := make([]Hold, 0, len(active))
+	for _, hold := range active {
+		holds = append(holds, hold)
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].PluginID < holds[j].PluginID })
+	return holds, nil
+}
+
+// Hold holds updates of a plugin at runtime. it replaces the runtime hold of the same plugin.
+// holds are kept in the KV store so they're shared by all nodes and survive restarts.
+func (u *Updater) Hold(hold Hold) error {
+	if err := hold.validate(); err != nil {
+		return err
+	}
+	return u.modifyHolds(func(holds map[string]Hold) bool {
+		holds[hold.PluginID] = hold
+		return true
+	})
+}
+
+// Unhold removes the runtime hold of plugin with id. holds set by HoldsOption cannot be removed
+// at runtime. ErrHoldNotFound is returned when there is no runtime hold for the plugin.
+func (u *Updater) Unhold(id string) error {
+	found := false
+	err := u.modifyHolds(func(holds map[string]Hold) bool {
+		_, found = holds[id]
+		delete(holds, id)
+		return found
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrHoldNotFound
+	}
+	return nil
+}
+
+// activeHolds returns the active holds in conf and the KV store at now by plugin ids.
+func (u *Updater) activeHolds(conf config, now time.Time) (map[string]Hold, error) {
+	stored, _, err := u.loadHolds()
+	if err != nil {
+		return nil, err
+	}
+	holds := make(map[string]Hold)
+	for _, list := range [][]Hold{conf.holds, stored} {
+		for _, hold := range list {
+			if hold.active(now) {
+				holds[hold.PluginID] = hold
+			}
+		}
+	}
+	return holds, nil
+}
+
+// modifyHolds modifies the holds in the KV store with modify by plugin ids. the holds are only
+// saved when modify reports a change. it retries on concurrent modifications since holds can be
+// modified by multiple nodes at the same time. expired holds are dropped while saving.
+func (u *Updater) modifyHolds(modify func(holds map[string]Hold) bool) error {
+	now := time.Now()
+	for i := 0; i < maxHoldsWriteAttempts; i++ {
+		stored, data, err := u.loadHolds()
+		if err != nil {
+			return err
+		}
+		holds := make(map[string]Hold, len(stored))
+		for _, hold := range stored {
+			holds[hold.PluginID] = hold
+		}
+		if !modify(holds) {
+			return nil
+		}
+		var newHolds []Hold
+		for _, hold := range holds {
+			if hold.active(now) {
+				newHolds = append(newHolds, hold)
+			}
+		}
+		sort.Slice(newHolds, func(i, j int) bool { return newHolds[i].PluginID < newHolds[j].PluginID })
+		newData, err := json.Marshal(newHolds)
+		if err != nil {
+			return err
+		}
+		ok, aerr := u.papi.KVCompareAndSet(holdsKey, data, newData)
+		if aerr != nil {
+			return aerr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("holds are modified concurrently too many times")
+}
+
+// loadHolds loads the holds in the KV store with their raw data.
+func (u *Updater) loadHolds() ([]Hold, []byte, error) {
+	data, aerr := u.papi.KVGet(holdsKey)
+	if aerr != nil {
+		return nil, nil, errors.Wrap(aerr, "cannot get the holds")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var holds []Hold
+	if err := json.Unmarshal(data, &holds); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot decode the holds")
+	}
+	return holds, data, nil
+}
@@ -0,0 +1,134 @@
+package updater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHolds(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	stored := mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "jira", Version: "1.4.2"},
+		{Id: "github", Version: "1.0.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "1.5.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "1.4.5"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: "2.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.1.0"}}},
+	}, nil)
+
+	configHolds, err := ParseHolds("github: waiting for vendor fix\nzoom until 2000-01-01")
+	require.NoError(t, err)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), HoldsOption(configHolds))
+	until := time.Date(2099, 12, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, updater.Hold(Hold{PluginID: "jira", Version: "1.4.x", Until: until, Reason: "stay on 1.4"}))
+	require.Error(t, updater.Hold(Hold{PluginID: "jira", Version: "latest"}))
+	require.Len(t, *stored, 1)
+
+	holds, err := updater.Holds()
+	require.NoError(t, err)
+	require.Equal(t, []Hold{
+		{PluginID: "github", Reason: "waiting for vendor fix"},
+		{PluginID: "jira", Version: "1.4.x", Until: until, Reason: "stay on 1.4"},
+	}, holds, "expired holds should not be listed")
+
+	statuses, err := updater.Status(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []PluginStatus{
+		{PluginID: "jira", InstalledVersion: "1.4.2", LatestVersion: "1.4.5"},
+		{PluginID: "github", InstalledVersion: "1.0.0", LatestVersion: "2.0.0", Err: &HoldError{
+			PluginID:          "github",
+			NextPluginVersion: "2.0.0",
+			Hold:              Hold{PluginID: "github", Reason: "waiting for vendor fix"},
+		}},
+		{PluginID: "zoom", InstalledVersion: "1.0.0", LatestVersion: "1.1.0"},
+	}, statuses)
+	require.Equal(t, `"2.0.0" version of "github" plugin is held: waiting for vendor fix`, statuses[1].Err.Error())
+
+	// runtime holds overwrite the config holds but cannot remove them.
+	require.NoError(t, updater.Hold(Hold{PluginID: "github", Version: "1.x"}))
+	holds, err = updater.Holds()
+	require.NoError(t, err)
+	require.Equal(t, Hold{PluginID: "github", Version: "1.x"}, holds[0])
+	require.NoError(t, updater.Unhold("github"))
+	require.Equal(t, ErrHoldNotFound, updater.Unhold("github"))
+	holds, err = updater.Holds()
+	require.NoError(t, err)
+	require.Equal(t, Hold{PluginID: "github", Reason: "waiting for vendor fix"}, holds[0])
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestCanBeUpdatedHold(t *testing.T) {
+	tests := []struct {
+		next string
+		hold *Hold
+		held bool
+	}{
+		{"1.3.0", nil, false},
+		{"1.3.0", &Hold{PluginID: "github"}, true},
+		{"1.3.0", &Hold{PluginID: "github", Until: time.Now().Add(-time.Hour)}, false},
+		{"1.2.4", &Hold{PluginID: "github", Version: "1.2.x"}, false},
+		{"1.3.0", &Hold{PluginID: "github", Version: "1.2.x"}, true},
+		{"1.3.0", &Hold{PluginID: "github", Version: ">=1.2.0 <2.0.0"}, false},
+	}
+	for _, tt := range tests {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: "github", Version: "1.2.3"},
+			&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: tt.next}},
+			nil, "5.18.0", HoldUpdateOpOption(tt.hold))
+		require.NoError(t, err)
+		err = updateOp.CanBeUpdated()
+		if tt.held {
+			require.Equal(t, &HoldError{PluginID: "github", NextPluginVersion: tt.next, Hold: *tt.hold}, err)
+		} else {
+			require.NoError(t, err, "%s with %v", tt.next, tt.hold)
+		}
+	}
+}
+
+func TestParseHold(t *testing.T) {
+	tests := []struct {
+		s           string
+		hold        Hold
+		description string
+	}{
+		{"jira", Hold{PluginID: "jira"}, "held"},
+		{"jira@1.4.x: waiting for vendor fix", Hold{PluginID: "jira", Version: "1.4.x", Reason: "waiting for vendor fix"},
+			`pinned to "1.4.x": waiting for vendor fix`},
+		{"github until 2026-12-01: reason: with colons", Hold{
+			PluginID: "github",
+			Until:    time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC),
+			Reason:   "reason: with colons",
+		}, "held until 2026-12-01: reason: with colons"},
+	}
+	for _, tt := range tests {
+		hold, err := ParseHold(tt.s)
+		require.NoError(t, err)
+		require.Equal(t, tt.hold, hold)
+		require.Equal(t, tt.s, hold.String())
+		require.Equal(t, tt.description, hold.Describe())
+	}
+
+	for _, s := range []string{"", "jira@latest", "jira until tomorrow", "jira later 2026-12-01"} {
+		_, err := ParseHold(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"context"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// Check immediately checks for new versions of installed plugins and updates them in the
+// background without waiting for the next update round.
+func (u *Updater) Check() error {
+	return u.goJob(u.checkAndUpdate)
+}
+
+// UpdatePlugin immediately updates the installed plugin with id to its latest version in the
+// Marketplace. the skip list, holds, update policies, the min release age, approvals and rolled back
+// versions are not applied to manual updates.
+// the update is made in the background and its result is sent as a notification.
+func (u *Updater) UpdatePlugin(id string) error {
+	conf := u.cloneConfing()
+	if conf.dryRun {
+		return ErrDryRun
+	}
+	conf.skipPlugins = nil
+	conf.policy = PolicyMajor
+	conf.pluginPolicies = nil
+	conf.minReleaseAge = 0
+	candidates, err := u.listCandidates(context.Background(), conf, nil, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		if c.installed.Id != id {
+			continue
+		}
+		if c.err != nil {
+			return c.err
+		}
+		dl, err := u.lockPlugin(id)
+		if err != nil {
+			return err
+		}
+		// a manual update has its own correlation id.
+		c.updateOp.checkID = model.NewId()
+		err = u.goJob(func() {
+			defer dl.Unlock()
+			u.update(c.updateOp)
+		})
+		if err != nil {
+			dl.Unlock()
+		}
+		return err
+	}
+	return ErrPluginNotInstalled
+}
+
+// SkipPlugin holds all updates of plugin with id indefinitely so it'll not be updated by the
+// update checks until the hold is removed by Unhold.
+func (u *Updater) SkipPlugin(id string) error {
+	return u.Hold(Hold{PluginID: id, Reason: "skipped"})
+}
+
+// goJob runs job in a new goroutine unless Updater is stopped.
+func (u *Updater) goJob(job func()) error {
+	u.mj.Lock()
+	defer u.mj.Unlock()
+	if u.stopped {
+		return ErrStopped
+	}
+	u.jobs.Add(1)
+	go func() {
+		defer u.jobs.Done()
+		job()
+	}()
+	return nil
+}
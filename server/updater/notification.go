@@ -1,5 +1,7 @@
 package updater
 
+import "time"
+
 // Notification is sent when a plugin is updated, cannot be updated or if any
 // error occurred during the update process.
 type Notification struct {
@@ -10,6 +12,22 @@ type Notification struct {
 	// when a successful update is made.
 	Updated *Changelog
 
+	// Planned contains information about an update that would be made and only filled
+	// in dry-run mode. Error is set when the update would not be made.
+	Planned *Changelog
+
+	// Queued contains information about an update that waits for a maintenance window to be
+	// installed and only filled when an update is queued.
+	Queued *Changelog
+
+	// QueuedUntil is the opening time of the maintenance window that a queued update
+	// will be installed in.
+	QueuedUntil time.Time
+
+	// ApprovalRequested contains information about an update that waits for an approval and
+	// only filled when an approval is requested. the update is approved by Updater.Approve.
+	ApprovalRequested *Changelog
+
 	// Error can be a reason about why an update cannot be made, failed or can be
 	// any other error.
 	Error error
@@ -40,10 +58,33 @@ func (u *Updater) notifyUpdated(pluginID string, changelog Changelog) {
 	u.sendNotification(Notification{PluginID: pluginID, Updated: &changelog})
 }
 
-// sendNotification sends a notification to notification listener.
+// notifyPlanned sends notification about an update that would be made in dry-run mode.
+// status.Err is the reason when the update would not be made.
+func (u *Updater) notifyPlanned(status PluginStatus) {
+	u.sendNotification(Notification{
+		PluginID: status.PluginID,
+		Planned: &Changelog{
+			PreviousVersion: status.InstalledVersion,
+			UpdatedVersion:  status.LatestVersion,
+		},
+		Error: status.Err,
+	})
+}
+
+// notifyQueued sends notification about an update that is queued until the maintenance window
+// that opens at until.
+func (u *Updater) notifyQueued(updateOp *UpdateOp, until time.Time) {
+	changelog := updateOp.CreateChangelog()
+	u.sendNotification(Notification{PluginID: updateOp.installed.Id, Queued: &changelog, QueuedUntil: until})
+}
+
+// notifyApprovalRequested sends notification about an update that waits for an approval.
+func (u *Updater) notifyApprovalRequested(updateOp *UpdateOp) {
+	changelog := updateOp.CreateChangelog()
+	u.sendNotification(Notification{PluginID: updateOp.installed.Id, ApprovalRequested: &changelog})
+}
+
+// sendNotification sends a notification to the subscribers.
 func (u *Updater) sendNotification(notification Notification) {
-	conf := u.cloneConfing()
-	if conf.notifications != nil {
-		conf.notifications <- notification
-	}
+	u.notifications.publish(notification)
 }
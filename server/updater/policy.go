@@ -0,0 +1,102 @@
+package updater
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
+)
+
+// UpdatePolicy restricts updates by the semver level of the version jump.
+type UpdatePolicy string
+
+const (
+	// PolicyPatch only allows patch updates, e.g. 1.2.0 to 1.2.3.
+	PolicyPatch UpdatePolicy = "patch"
+
+	// PolicyMinor allows minor and patch updates, e.g. 1.2.0 to 1.4.1.
+	PolicyMinor UpdatePolicy = "minor"
+
+	// PolicyMajor allows all updates including the ones with breaking changes, e.g. 1.2.0 to 2.0.0.
+	PolicyMajor UpdatePolicy = "major"
+)
+
+// rank returns an order of policy from the most restrictive to the least restrictive.
+func (p UpdatePolicy) rank() int {
+	switch p {
+	case PolicyPatch:
+		return 0
+	case PolicyMinor:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// allows checks if p allows an update that requires the required policy.
+func (p UpdatePolicy) allows(required UpdatePolicy) bool {
+	return p.rank() >= required.rank()
+}
+
+// validate checks if p is a known policy.
+func (p UpdatePolicy) validate() error {
+	switch p {
+	case PolicyPatch, PolicyMinor, PolicyMajor:
+		return nil
+	}
+	return fmt.Errorf("unknown update policy %q", p)
+}
+
+// requiredPolicy returns the least restrictive policy needed to update from a version to
+// the newer to version.
+func requiredPolicy(from, to semver.Version) UpdatePolicy {
+	switch {
+	case to.Major != from.Major:
+		return PolicyMajor
+	case to.Minor != from.Minor:
+		return PolicyMinor
+	default:
+		return PolicyPatch
+	}
+}
+
+// ParseUpdatePolicy parses a policy from s. an empty s is parsed as PolicyMinor.
+func ParseUpdatePolicy(s string) (UpdatePolicy, error) {
+	if s == "" {
+		return PolicyMinor, nil
+	}
+	policy := UpdatePolicy(s)
+	return policy, policy.validate()
+}
+
+// ParsePluginUpdatePolicies parses per plugin policies from s in the form of
+// "github:patch, jira:minor".
+func ParsePluginUpdatePolicies(s string) (map[string]UpdatePolicy, error) {
+	policies := make(map[string]UpdatePolicy)
+	for _, entry := range xstrings.SplitList(s) {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid plugin update policy %q, it should be in the form of <plugin-id>:<policy>", entry)
+		}
+		id := strings.TrimSpace(parts[0])
+		policy := UpdatePolicy(strings.TrimSpace(parts[1]))
+		if err := policy.validate(); err != nil {
+			return nil, err
+		}
+		policies[id] = policy
+	}
+	return policies, nil
+}
+
+// FormatPluginUpdatePolicies formats per plugin policies in the form that
+// ParsePluginUpdatePolicies parses, sorted by plugin ids.
+func FormatPluginUpdatePolicies(policies map[string]UpdatePolicy) string {
+	entries := make([]string, 0, len(policies))
+	for id, policy := range policies {
+		entries = append(entries, fmt.Sprintf("%s:%s", id, policy))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ", ")
+}
@@ -0,0 +1,85 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy defines how failed Marketplace listings and bundle downloads are retried.
+type RetryPolicy struct {
+	// Attempts is the max number of attempts including the first one.
+	// retrying is disabled when it is less than 2.
+	Attempts int
+
+	// BaseDelay is the wait time before the first retry, it is doubled on every retry.
+	BaseDelay time.Duration
+
+	// MaxDelay is the max wait time between retries.
+	MaxDelay time.Duration
+
+	// Jitter is the fraction of the wait time that is randomized, between 0 and 1.
+	// unlike other fields, zero is not replaced with the default and disables the jitter.
+	Jitter float64
+}
+
+// defaultRetryPolicy used as a default policy to retry failed operations.
+// it is also used to fill the unset fields of a policy.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:  3,
+	BaseDelay: time.Second,
+	MaxDelay:  time.Second * 30,
+	Jitter:    0.2,
+}
+
+// delay returns the wait time before the retry after the attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// retry calls fn until it succeeds, fails with an error that is not retryable, all attempts are
+// made or ctx is done. op is the name of the operation used in logs.
+func (u *Updater) retry(ctx context.Context, op string, fn func() error) error {
+	policy := u.cloneConfing().retryPolicy
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.Attempts || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+		delay := policy.delay(attempt)
+		u.papi.LogWarn(fmt.Sprintf("%s failed, retrying in %s (attempt %d/%d): %s", op, delay, attempt,
+			policy.Attempts, err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// isRetryable checks if the operation that failed with err might succeed on retry. errors that
+// know it report it with a Retryable() method, cancelled operations are never retried.
+func isRetryable(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case interface{ Retryable() bool }:
+		return e.Retryable()
+	}
+	switch errors.Cause(err) {
+	case context.Canceled, context.DeadlineExceeded:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package updater
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"client error", &marketplace.APIError{StatusCode: http.StatusBadRequest}, false},
+		{"rate limit", &marketplace.APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"server error", errors.Wrap(&marketplace.APIError{StatusCode: http.StatusBadGateway}, "cannot list"), true},
+		{"missing bundle", &xplugin.StatusError{StatusCode: http.StatusNotFound}, false},
+		{"invalid bundle", &xplugin.InvalidBundleError{}, false},
+		{"pagination", &marketplace.PaginationError{Pages: 100}, false},
+		{"cancelled", errors.Wrap(context.Canceled, "cannot download"), false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.retryable, isRetryable(tt.err), tt.name)
+	}
+}
@@ -0,0 +1,283 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultHealthCheckTimeout used as a default time to wait for an updated plugin to run.
+	defaultHealthCheckTimeout = time.Minute
+
+	// healthCheckInterval is the wait time between plugin status checks.
+	healthCheckInterval = time.Second
+
+	// backupKeyPrefix used to prefix KV store keys of the previously installed plugin bundles.
+	backupKeyPrefix = "marketplace-addon:backup:"
+
+	// backupChunkSize is the max size of a chunk of a backed up bundle. bundles are saved in
+	// chunks since they can be larger than a KV store value can keep.
+	backupChunkSize = 512 << 10
+
+	// rolledBackKey is the KV store key of the versions that are rolled back by plugin ids.
+	rolledBackKey = "marketplace-addon:rolled-back"
+
+	// maxRolledBackWriteAttempts is the max number of attempts to save the rolled back versions
+	// when they're modified concurrently.
+	maxRolledBackWriteAttempts = 10
+)
+
+// isRunning checks if plugin with id is running.
+func (u *Updater) isRunning(id string) (bool, error) {
+	status, aerr := u.papi.GetPluginStatus(id)
+	if aerr != nil {
+		return false, errors.Wrapf(aerr, "cannot get the status of %q plugin", id)
+	}
+	return status.State == model.PluginStateRunning, nil
+}
+
+// waitRunning waits for plugin with id to run until timeout.
+func (u *Updater) waitRunning(id string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, aerr := u.papi.GetPluginStatus(id)
+		if aerr != nil {
+			return errors.Wrap(aerr, "cannot get the status of the plugin")
+		}
+		switch status.State {
+		case model.PluginStateRunning:
+			return nil
+		case model.PluginStateFailedToStart:
+			return errors.New("plugin failed to start")
+		case model.PluginStateFailedToStayRunning:
+			return errors.New("plugin failed to stay running")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("plugin is not running after %s", timeout)
+		}
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// backupInfo describes a backed up bundle that is saved in chunks.
+type backupInfo struct {
+	// Chunks is the number of chunks of the bundle.
+	Chunks int `json:"chunks"`
+
+	// Size is the size of the bundle.
+	Size int `json:"size"`
+}
+
+// backupChunkKey returns the KV store key of the ith chunk of the backed up bundle of plugin with id.
+func backupChunkKey(id string, i int) string {
+	return fmt.Sprintf("%s%s:%d", backupKeyPrefix, id, i)
+}
+
+// backup keeps the bundle of the installed plugin with id in the KV store, so it can be
+// restored when its update fails.
+// the bundle is created from the plugin's directory that lives next to this plugin's bundle.
+// it is saved in chunks after its info, so the chunks of an interrupted backup can still be deleted.
+// saving the chunks is cancelled when ctx is done.
+func (u *Updater) backup(ctx context.Context, id string) error {
+	bundlePath, err := u.papi.GetBundlePath()
+	if err != nil {
+		return errors.Wrap(err, "cannot get the bundle path")
+	}
+	bundle, err := xplugin.BundleFromDir(filepath.Join(filepath.Dir(bundlePath), id))
+	if err != nil {
+		return err
+	}
+	// delete the leftovers of a previous backup.
+	u.deleteBackup(id)
+	info := backupInfo{
+		Chunks: (len(bundle) + backupChunkSize - 1) / backupChunkSize,
+		Size:   len(bundle),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if aerr := u.papi.KVSet(backupKeyPrefix+id, data); aerr != nil {
+		return errors.Wrap(aerr, "cannot save the bundle")
+	}
+	for i := 0; i < info.Chunks; i++ {
+		if err := ctx.Err(); err != nil {
+			u.deleteBackup(id)
+			return errors.Wrap(err, "cannot save the bundle")
+		}
+		end := (i + 1) * backupChunkSize
+		if end > len(bundle) {
+			end = len(bundle)
+		}
+		if aerr := u.papi.KVSet(backupChunkKey(id, i), bundle[i*backupChunkSize:end]); aerr != nil {
+			u.deleteBackup(id)
+			return errors.Wrap(aerr, "cannot save the bundle")
+		}
+	}
+	return nil
+}
+
+// loadBackupInfo loads the info of the backed up bundle of plugin with id. it returns nil when
+// there is no backup.
+func (u *Updater) loadBackupInfo(id string) (*backupInfo, error) {
+	data, aerr := u.papi.KVGet(backupKeyPrefix + id)
+	if aerr != nil {
+		return nil, errors.Wrap(aerr, "cannot get the backup bundle")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var info backupInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.Wrap(err, "invalid backup bundle")
+	}
+	return &info, nil
+}
+
+// restore installs the backed up bundle of plugin with id.
+func (u *Updater) restore(id string) error {
+	info, err := u.loadBackupInfo(id)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return errors.New("there is no backup bundle")
+	}
+	var bundle bytes.Buffer
+	for i := 0; i < info.Chunks; i++ {
+		chunk, aerr := u.papi.KVGet(backupChunkKey(id, i))
+		if aerr != nil {
+			return errors.Wrap(aerr, "cannot get the backup bundle")
+		}
+		bundle.Write(chunk)
+	}
+	if bundle.Len() != info.Size {
+		return errors.New("the backup bundle is incomplete")
+	}
+	if _, aerr := u.papi.InstallPlugin(&bundle, true); aerr != nil {
+		return errors.Wrap(aerr, "cannot install the backup bundle")
+	}
+	return nil
+}
+
+// deleteBackup deletes the backed up bundle of plugin with id.
+func (u *Updater) deleteBackup(id string) {
+	info, err := u.loadBackupInfo(id)
+	if err != nil {
+		u.papi.LogError(errors.Wrapf(err, "cannot delete the backup bundle of %q", id).Error())
+	}
+	if info == nil && err == nil {
+		return
+	}
+	if info != nil {
+		for i := 0; i < info.Chunks; i++ {
+			if aerr := u.papi.KVDelete(backupChunkKey(id, i)); aerr != nil {
+				u.papi.LogError(errors.Wrapf(aerr, "cannot delete the backup bundle of %q", id).Error())
+			}
+		}
+	}
+	if aerr := u.papi.KVDelete(backupKeyPrefix + id); aerr != nil {
+		u.papi.LogError(errors.Wrapf(aerr, "cannot delete the backup bundle of %q", id).Error())
+	}
+}
+
+// rollback restores the previous version of the plugin updated by updateOp when the new
+// version fails the health checks with reason.
+// it returns with a *RollbackError when the previous version is restored.
+func (u *Updater) rollback(updateOp *UpdateOp, reason error) error {
+	id := updateOp.installed.Id
+	if err := u.restore(id); err != nil {
+		return errors.Wrapf(err, "cannot roll back %q version that is not healthy (%s)",
+			updateOp.next.Manifest.Version, reason)
+	}
+	return &RollbackError{
+		PluginID:              id,
+		FailedPluginVersion:   updateOp.next.Manifest.Version,
+		RestoredPluginVersion: updateOp.installed.Version,
+		Err:                   reason,
+	}
+}
+
+// rolledBackVersions returns the rolled back versions by plugin ids.
+func (u *Updater) rolledBackVersions() (map[string]string, error) {
+	versions, _, err := u.loadRolledBack()
+	return versions, err
+}
+
+// markRolledBack saves version of plugin with id as rolled back, so it is not installed again by
+// the update checks.
+func (u *Updater) markRolledBack(id, version string) {
+	err := u.modifyRolledBack(func(versions map[string]string) bool {
+		versions[id] = version
+		return true
+	})
+	if err != nil {
+		u.papi.LogError(errors.Wrapf(err, "cannot save the rolled back version of %q", id).Error())
+	}
+}
+
+// clearRolledBack forgets the rolled back version of plugin with id once it is updated.
+func (u *Updater) clearRolledBack(id string) {
+	err := u.modifyRolledBack(func(versions map[string]string) bool {
+		_, ok := versions[id]
+		delete(versions, id)
+		return ok
+	})
+	if err != nil {
+		u.papi.LogError(errors.Wrapf(err, "cannot delete the rolled back version of %q", id).Error())
+	}
+}
+
+// modifyRolledBack modifies the rolled back versions in the KV store with modify by plugin ids.
+// the versions are only saved when modify reports a change. it retries on concurrent
+// modifications since updates are made concurrently.
+func (u *Updater) modifyRolledBack(modify func(versions map[string]string) bool) error {
+	for i := 0; i < maxRolledBackWriteAttempts; i++ {
+		versions, data, err := u.loadRolledBack()
+		if err != nil {
+			return err
+		}
+		if versions == nil {
+			versions = make(map[string]string)
+		}
+		if !modify(versions) {
+			return nil
+		}
+		newData, err := json.Marshal(versions)
+		if err != nil {
+			return err
+		}
+		ok, aerr := u.papi.KVCompareAndSet(rolledBackKey, data, newData)
+		if aerr != nil {
+			return aerr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("rolled back versions are modified concurrently too many times")
+}
+
+// loadRolledBack loads the rolled back versions in the KV store with their raw data.
+func (u *Updater) loadRolledBack() (map[string]string, []byte, error) {
+	data, aerr := u.papi.KVGet(rolledBackKey)
+	if aerr != nil {
+		return nil, nil, errors.Wrap(aerr, "cannot get the rolled back versions")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var versions map[string]string
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, nil, errors.Wrap(err, "cannot decode the rolled back versions")
+	}
+	return versions, data, nil
+}
@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/pkg/errors"
+)
+
+const (
+	// firstSeenKeyPrefix is the KV store key prefix of the times that the latest versions of
+	// plugins are first seen in the Marketplace.
+	firstSeenKeyPrefix = "marketplace-addon:seen:"
+
+	// maxFirstSeenWriteAttempts is the max number of attempts to save a first seen time when it's
+	// concurrently modified.
+	maxFirstSeenWriteAttempts = 10
+)
+
+// firstSeen is the time that a version of a plugin is first seen in the Marketplace.
+// only the latest version of a plugin is kept, so a newer version replaces it.
+type firstSeen struct {
+	// Version of the plugin.
+	Version string `json:"version"`
+
+	// Time that the version is first seen at.
+	Time time.Time `json:"time"`
+}
+
+// releasedAt returns the time that plugin is released at. the time published by the Marketplace
+// is used when available, otherwise the time that the version of the plugin is first seen by the
+// updater is used and saved to the KV store to be used on the next checks.
+func (u *Updater) releasedAt(plugin *marketplace.Plugin, now time.Time) (time.Time, error) {
+	if !plugin.UpdatedAt.IsZero() {
+		return plugin.UpdatedAt, nil
+	}
+	key := firstSeenKeyPrefix + plugin.Manifest.Id
+	for attempt := 0; attempt < maxFirstSeenWriteAttempts; attempt++ {
+		seen, data, err := u.loadFirstSeen(key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if seen != nil && seen.Version == plugin.Manifest.Version {
+			return seen.Time, nil
+		}
+		// another node might save it first, in that case its time is used on the next attempt.
+		seen = &firstSeen{Version: plugin.Manifest.Version, Time: now.UTC().Truncate(time.Second)}
+		newData, err := json.Marshal(seen)
+		if err != nil {
+			return time.Time{}, err
+		}
+		ok, aerr := u.papi.KVCompareAndSet(key, data, newData)
+		if aerr != nil {
+			return time.Time{}, errors.Wrap(aerr, "cannot save the first seen time")
+		}
+		if ok {
+			return seen.Time, nil
+		}
+	}
+	return time.Time{}, errors.New("cannot save the first seen time, it is modified concurrently")
+}
+
+// deleteFirstSeen deletes the first seen time of version of plugin with id once it's installed.
+// the time of a newer version is kept.
+func (u *Updater) deleteFirstSeen(id, version string) {
+	key := firstSeenKeyPrefix + id
+	seen, data, err := u.loadFirstSeen(key)
+	if err == nil && (seen == nil || seen.Version != version) {
+		return
+	}
+	if err == nil {
+		_, aerr := u.papi.KVCompareAndDelete(key, data)
+		if aerr == nil {
+			return
+		}
+		err = aerr
+	}
+	u.papi.LogError(errors.Wrapf(err, "cannot delete the first seen time of %q", id).Error())
+}
+
+// loadFirstSeen loads the first seen time saved with key with its raw data. an invalid first
+// seen time is returned as nil with its data so it can be replaced.
+func (u *Updater) loadFirstSeen(key string) (*firstSeen, []byte, error) {
+	data, aerr := u.papi.KVGet(key)
+	if aerr != nil {
+		return nil, nil, errors.Wrap(aerr, "cannot get the first seen time")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	var seen firstSeen
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, data, nil
+	}
+	return &seen, data, nil
+}
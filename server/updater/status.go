@@ -0,0 +1,241 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// PluginStatus shows an installed plugin's version against its latest version in the Marketplace.
+type PluginStatus struct {
+	// PluginID is the id of the plugin.
+	PluginID string
+
+	// InstalledVersion is the currently installed version of the plugin.
+	InstalledVersion string
+
+	// LatestVersion is the latest version of the plugin in the Marketplace.
+	// it is empty when the plugin is not in the Marketplace.
+	LatestVersion string
+
+	// Err is the reason why the plugin cannot be updated to its latest version.
+	// it is nil when the plugin can be updated.
+	Err error
+}
+
+// candidate is an installed plugin that might be updated to its latest version in the Marketplace.
+type candidate struct {
+	// installed represents installed plugin.
+	installed *model.Manifest
+
+	// latest represents the latest version of the plugin in the Marketplace that it can be
+	// updated to, or the latest version in its release channel when there is none.
+	// it is nil when the plugin is not in the Marketplace.
+	latest *marketplace.Plugin
+
+	// updateOp is the update operation to the latest version of the plugin.
+	// it is nil when the plugin is not in the Marketplace or an update operation cannot be created.
+	updateOp *UpdateOp
+
+	// err is the reason why the plugin cannot be updated, it is nil when it can be.
+	err error
+}
+
+// Status lists all installed plugins with their latest versions in the Marketplace by
+// applying the same rules used while discovering plugins to update.
+// retrying to list Marketplace plugins is stopped once ctx is done.
+func (u *Updater) Status(ctx context.Context) ([]PluginStatus, error) {
+	candidates, err := u.candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]PluginStatus, len(candidates))
+	for i, c := range candidates {
+		statuses[i] = PluginStatus{
+			PluginID:         c.installed.Id,
+			InstalledVersion: c.installed.Version,
+			Err:              c.err,
+		}
+		if c.latest != nil {
+			statuses[i].LatestVersion = c.latest.Manifest.Version
+		}
+	}
+	return statuses, nil
+}
+
+// Plan lists installed plugins that have newer versions in the Marketplace, including the ones
+// that cannot be updated to the newer versions. it is the plan of the next update check and
+// Err of a PluginStatus is the reason why the plugin would not be updated.
+// Plan does not install any plugins. retrying to list Marketplace plugins is stopped once ctx is done.
+func (u *Updater) Plan(ctx context.Context) ([]PluginStatus, error) {
+	candidates, err := u.candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var plan []PluginStatus
+	for _, c := range candidates {
+		if !c.hasNewerVersion() {
+			continue
+		}
+		plan = append(plan, PluginStatus{
+			PluginID:         c.installed.Id,
+			InstalledVersion: c.installed.Version,
+			LatestVersion:    c.latest.Manifest.Version,
+			Err:              c.err,
+		})
+	}
+	return plan, nil
+}
+
+// hasNewerVersion checks if the plugin might have a newer version in the Marketplace.
+// plugins with versions that cannot be compared are assumed to have newer versions.
+func (c *candidate) hasNewerVersion() bool {
+	if c.latest == nil {
+		return false
+	}
+	if c.updateOp == nil {
+		return true
+	}
+	return c.updateOp.nextSemver.GT(c.updateOp.installedSemver)
+}
+
+// candidates lists installed plugins with the update operations to their latest versions
+// in the Marketplace by using the current config, holds and rolled back versions.
+func (u *Updater) candidates(ctx context.Context) ([]*candidate, error) {
+	conf := u.cloneConfing()
+	holds, err := u.activeHolds(conf, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rolledBack, err := u.rolledBackVersions()
+	if err != nil {
+		return nil, err
+	}
+	return u.listCandidates(ctx, conf, holds, rolledBack)
+}
+
+// listCandidates lists installed plugins with the update operations to their latest versions
+// in the Marketplace by using conf, holds and rolled back versions by plugin ids.
+// retrying to list Marketplace plugins is stopped once ctx is done.
+func (u *Updater) listCandidates(ctx context.Context, conf config, holds map[string]Hold, rolledBack map[string]string) ([]*candidate, error) {
+	// get a list of installed plugins.
+	installedPlugins, aerr := u.papi.GetPlugins()
+	if aerr != nil {
+		return nil, errors.Wrap(aerr, "cannot get a list of installed plugins")
+	}
+	u.papi.LogInfo(fmt.Sprintf("found %d installed plugins", len(installedPlugins)))
+	// if there are no installed plugins, there is nothing to update.
+	if len(installedPlugins) == 0 {
+		return nil, nil
+	}
+	// get a list of Marketplace plugins.
+	var marketplacePlugins marketplace.Plugins
+	err := u.retry(ctx, "listing Marketplace plugins", func() (err error) {
+		marketplacePlugins, err = conf.marketplace.ListPlugins()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get a list of plugins from Marketplace")
+	}
+	u.papi.LogInfo(fmt.Sprintf("found %d plugins in the marketplace", len(marketplacePlugins)))
+	serverVersion := u.papi.GetServerVersion()
+	now := time.Now()
+	// check every installed plugin to see if there is new versions.
+	candidates := make([]*candidate, len(installedPlugins))
+	for i, manifest := range installedPlugins {
+		c := &candidate{installed: manifest}
+		candidates[i] = c
+		// get the last version of the installed plugin from the Marketplace.
+		channel := conf.pluginChannel(manifest.Id)
+		policy := conf.pluginPolicy(manifest.Id)
+		c.latest, c.err = latestRelease(marketplacePlugins, manifest.Id, channel.allows)
+		options := []UpdateOpOption{
+			PolicyUpdateOpOption(policy),
+			ReleaseChannelUpdateOpOption(channel),
+		}
+		var hold *Hold
+		if h, ok := holds[manifest.Id]; ok {
+			hold = &h
+			options = append(options, HoldUpdateOpOption(hold))
+			// prefer the latest version in the pinned version range when there is one.
+			pinned, err := latestRelease(marketplacePlugins, manifest.Id, func(version semver.Version) bool {
+				return channel.allows(version) && hold.allows(version)
+			})
+			if err == nil {
+				c.latest, c.err = pinned, nil
+			}
+		}
+		// prefer the latest version that the plugin can be updated to, so a newer version that is
+		// not allowed by the policy or not compatible with the server does not hide it.
+		if allowed := allowedRelease(marketplacePlugins, manifest, serverVersion, channel, hold, policy); allowed != nil {
+			c.latest, c.err = allowed, nil
+		}
+		if c.err != nil {
+			continue
+		}
+		// a rolled back version is not installed again until a newer version is released.
+		if version, ok := rolledBack[manifest.Id]; ok && version == c.latest.Manifest.Version {
+			c.err = &RolledBackVersionError{PluginID: manifest.Id, NextPluginVersion: version}
+			continue
+		}
+		if conf.minReleaseAge > 0 {
+			releasedAt, err := u.releasedAt(c.latest, now)
+			if err != nil {
+				c.err = errors.Wrapf(err, "cannot get the release time of %q version of %q",
+					c.latest.Manifest.Version, manifest.Id)
+				continue
+			}
+			options = append(options, SoakUpdateOpOption(releasedAt, conf.minReleaseAge))
+		}
+		// create a new update operation for installed plugin and its version in the marketplace.
+		c.updateOp, c.err = NewUpdateOp(manifest, c.latest.BaseMarketplacePlugin, conf.skipPlugins,
+			serverVersion, options...)
+		if c.err != nil {
+			continue
+		}
+		c.err = c.updateOp.CanBeUpdated()
+	}
+	return candidates, nil
+}
+
+// allowedRelease returns the latest version of the installed plugin in plugins that is newer than
+// the installed version, allowed by channel, hold and policy and compatible with serverVersion.
+// it returns nil when there is no such version.
+func allowedRelease(plugins marketplace.Plugins, installed *model.Manifest, serverVersion string,
+	channel ReleaseChannel, hold *Hold, policy UpdatePolicy) *marketplace.Plugin {
+	installedSemver, err := semver.Parse(installed.Version)
+	if err != nil {
+		return nil
+	}
+	var compatible marketplace.Plugins
+	for _, plugin := range plugins {
+		if plugin.Manifest.Id != installed.Id {
+			continue
+		}
+		if _, err := semver.Parse(plugin.Manifest.Version); err != nil {
+			continue
+		}
+		if plugin.Manifest.MinServerVersion != "" {
+			ok, err := plugin.Manifest.MeetMinServerVersion(serverVersion)
+			if err != nil || !ok {
+				continue
+			}
+		}
+		compatible = append(compatible, plugin)
+	}
+	allowed, err := latestRelease(compatible, installed.Id, func(version semver.Version) bool {
+		return version.GT(installedSemver) &&
+			channel.allows(version) &&
+			(hold == nil || hold.allows(version)) &&
+			policy.allows(requiredPolicy(installedSemver, version))
+	})
+	if err != nil {
+		return nil
+	}
+	return allowed
+}
@@ -1,6 +1,8 @@
 package updater
 
 import (
+	"time"
+
 	"github.com/blang/semver"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xstrings"
 	"github.com/mattermost/mattermost-server/model"
@@ -23,26 +25,88 @@ type UpdateOp struct {
 	// skipList used to skip updating a list of plugins by their ids.
 	skipList []string
 
+	// hold holds updating the plugin when it is not nil.
+	hold *Hold
+
 	// serverVersion is the Mattermost server's version.
 	serverVersion string
+
+	// policy restricts the update by the semver level of the version jump.
+	policy UpdatePolicy
+
+	// channel restricts the update by the stability of the next version.
+	channel ReleaseChannel
+
+	// releasedAt is the time that the next plugin is released at.
+	releasedAt time.Time
+
+	// minReleaseAge is the min time that should pass after the next plugin is released.
+	minReleaseAge time.Duration
+
+	// approvedBy is the id of the user who approved the update. it is empty when the update
+	// does not require an approval.
+	approvedBy string
+
+	// checkID is the correlation id of the check round or manual update that makes the update.
+	checkID string
+}
+
+// UpdateOpOption used to customize UpdateOp defaults.
+type UpdateOpOption func(*UpdateOp)
+
+// PolicyUpdateOpOption sets an update policy to restrict the update by the semver level of
+// the version jump. PolicyMajor is used by default.
+func PolicyUpdateOpOption(policy UpdatePolicy) UpdateOpOption {
+	return func(u *UpdateOp) {
+		u.policy = policy
+	}
+}
+
+// ReleaseChannelUpdateOpOption sets a release channel to restrict the update by the stability of
+// the next version. ChannelBeta is used by default.
+func ReleaseChannelUpdateOpOption(channel ReleaseChannel) UpdateOpOption {
+	return func(u *UpdateOp) {
+		u.channel = channel
+	}
+}
+
+// SoakUpdateOpOption restricts the update to the next plugins that are released at least minAge
+// ago where releasedAt is the release time of the next plugin. there is no restriction by default.
+func SoakUpdateOpOption(releasedAt time.Time, minAge time.Duration) UpdateOpOption {
+	return func(u *UpdateOp) {
+		u.releasedAt = releasedAt
+		u.minReleaseAge = minAge
+	}
+}
+
+// HoldUpdateOpOption holds the update with hold of the plugin. there is no hold by default.
+func HoldUpdateOpOption(hold *Hold) UpdateOpOption {
+	return func(u *UpdateOp) {
+		u.hold = hold
+	}
 }
 
 // NewUpdateOp creates a new UpdateOp from installed and next plugin.
 func NewUpdateOp(installed *model.Manifest, next *model.BaseMarketplacePlugin, skipList []string,
-	serverVersion string) (*UpdateOp, error) {
+	serverVersion string, options ...UpdateOpOption) (*UpdateOp, error) {
 	u := &UpdateOp{
 		installed:     installed,
 		next:          next,
 		skipList:      skipList,
 		serverVersion: serverVersion,
+		policy:        PolicyMajor,
+		channel:       ChannelBeta,
+	}
+	for _, o := range options {
+		o(u)
 	}
 	installedSemver, err := semver.Parse(installed.Version)
 	if err != nil {
-		return nil, err
+		return nil, &VersionError{PluginID: installed.Id, Version: installed.Version, Err: err}
 	}
 	nextSemver, err := semver.Parse(next.Manifest.Version)
 	if err != nil {
-		return nil, err
+		return nil, &VersionError{PluginID: next.Manifest.Id, Version: next.Manifest.Version, Err: err}
 	}
 	u.installedSemver = installedSemver
 	u.nextSemver = nextSemver
@@ -61,15 +125,32 @@ func (u *UpdateOp) CanBeUpdated() error {
 	if err := u.requireNewerVersion(); err != nil {
 		return err
 	}
+	if err := u.requireAllowedByChannel(); err != nil {
+		return err
+	}
+	if err := u.requireAllowedByPolicy(); err != nil {
+		return err
+	}
+	if err := u.requireSoaked(); err != nil {
+		return err
+	}
 	return u.requireMinServerVersion()
 }
 
-// requireNotSkipped checks against if plugin is in the skip list.
+// requireNotSkipped checks against if plugin is in the skip list or held.
+// a plugin pinned to a version range can only be updated to the versions in the range.
 func (u *UpdateOp) requireNotSkipped() error {
 	if xstrings.SliceContains(u.skipList, u.installed.Id) {
 		return ErrPluginInSkipList
 	}
-	return nil
+	if u.hold == nil || !u.hold.active(time.Now()) || u.hold.allows(u.nextSemver) {
+		return nil
+	}
+	return &HoldError{
+		PluginID:          u.installed.Id,
+		NextPluginVersion: u.next.Manifest.Version,
+		Hold:              *u.hold,
+	}
 }
 
 // requireSamePlugin checks if installed and next plugins are the same plugins.
@@ -88,6 +169,51 @@ func (u *UpdateOp) requireNewerVersion() error {
 	return nil
 }
 
+// requireAllowedByChannel checks if the next plugin is released in the release channel.
+func (u *UpdateOp) requireAllowedByChannel() error {
+	if u.channel.allows(u.nextSemver) {
+		return nil
+	}
+	return &ReleaseChannelError{
+		PluginID:          u.installed.Id,
+		NextPluginVersion: u.next.Manifest.Version,
+		Channel:           u.channel,
+	}
+}
+
+// requireAllowedByPolicy checks if the update policy allows the version jump between installed
+// and next plugin.
+func (u *UpdateOp) requireAllowedByPolicy() error {
+	required := requiredPolicy(u.installedSemver, u.nextSemver)
+	if u.policy.allows(required) {
+		return nil
+	}
+	return &UpdatePolicyError{
+		PluginID:             u.installed.Id,
+		CurrentPluginVersion: u.installed.Version,
+		NextPluginVersion:    u.next.Manifest.Version,
+		Policy:               u.policy,
+		RequiredPolicy:       required,
+	}
+}
+
+// requireSoaked checks if the next plugin is released at least min release age ago.
+func (u *UpdateOp) requireSoaked() error {
+	if u.minReleaseAge <= 0 {
+		return nil
+	}
+	until := u.releasedAt.Add(u.minReleaseAge)
+	if !time.Now().Before(until) {
+		return nil
+	}
+	return &SoakError{
+		PluginID:          u.installed.Id,
+		NextPluginVersion: u.next.Manifest.Version,
+		ReleasedAt:        u.releasedAt,
+		Until:             until,
+	}
+}
+
 // requireMinServerVersion checks if the newer version of the plugin is compatible
 // with the Mattermost server.
 func (u *UpdateOp) requireMinServerVersion() error {
@@ -104,7 +230,7 @@ func (u *UpdateOp) requireMinServerVersion() error {
 	return &ServerVersionError{
 		PluginID:              u.installed.Id,
 		CurrentPluginVersion:  u.installed.Version,
-		NextPluginVersion:     u.next.Manifest.Id,
+		NextPluginVersion:     u.next.Manifest.Version,
 		CurrentServerVersion:  u.serverVersion,
 		RequiredServerVersion: u.next.Manifest.MinServerVersion,
 	}
@@ -0,0 +1,152 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanBeUpdatedPolicy(t *testing.T) {
+	tests := []struct {
+		next   string
+		policy UpdatePolicy
+		err    error
+	}{
+		{"1.2.4", PolicyPatch, nil},
+		{"1.3.0", PolicyPatch, &UpdatePolicyError{"github", "1.2.3", "1.3.0", PolicyPatch, PolicyMinor}},
+		{"1.3.0", PolicyMinor, nil},
+		{"2.0.0", PolicyMinor, &UpdatePolicyError{"github", "1.2.3", "2.0.0", PolicyMinor, PolicyMajor}},
+		{"2.0.0", PolicyMajor, nil},
+		{"1.2.3", PolicyMajor, ErrNoNewerVersion},
+	}
+	for _, tt := range tests {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: "github", Version: "1.2.3"},
+			&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: tt.next}},
+			nil, "5.18.0", PolicyUpdateOpOption(tt.policy))
+		require.NoError(t, err)
+		require.Equal(t, tt.err, updateOp.CanBeUpdated(), "%s with %s policy", tt.next, tt.policy)
+	}
+}
+
+func TestParseUpdatePolicy(t *testing.T) {
+	policy, err := ParseUpdatePolicy("patch")
+	require.NoError(t, err)
+	require.Equal(t, PolicyPatch, policy)
+
+	// an unset policy only allows minor and patch updates.
+	policy, err = ParseUpdatePolicy("")
+	require.NoError(t, err)
+	require.Equal(t, PolicyMinor, policy)
+	policy, _ = New(nil, nil, dlocktest.NewStore()).UpdatePolicies()
+	require.Equal(t, PolicyMinor, policy)
+
+	_, err = ParseUpdatePolicy("latest")
+	require.Equal(t, `unknown update policy "latest"`, err.Error())
+}
+
+func TestParsePluginUpdatePolicies(t *testing.T) {
+	policies, err := ParsePluginUpdatePolicies("github: patch, jira:minor")
+	require.NoError(t, err)
+	require.Equal(t, map[string]UpdatePolicy{"github": PolicyPatch, "jira": PolicyMinor}, policies)
+	require.Equal(t, "github:patch, jira:minor", FormatPluginUpdatePolicies(policies))
+
+	policies, err = ParsePluginUpdatePolicies(FormatPluginUpdatePolicies(nil))
+	require.NoError(t, err)
+	require.Empty(t, policies)
+
+	_, err = ParsePluginUpdatePolicies("github")
+	require.Error(t, err)
+
+	_, err = ParsePluginUpdatePolicies("github:latest")
+	require.Equal(t, `unknown update policy "latest"`, err.Error())
+}
+
+func TestCanBeUpdatedChannel(t *testing.T) {
+	tests := []struct {
+		installed string
+		next      string
+		channel   ReleaseChannel
+		err       error
+	}{
+		{"1.9.0", "2.0.0-rc1", ChannelStable, &ReleaseChannelError{"github", "2.0.0-rc1", ChannelStable}},
+		{"1.9.0", "2.0.0-rc1", ChannelRC, nil},
+		{"1.9.0", "2.0.0-beta.1", ChannelRC, &ReleaseChannelError{"github", "2.0.0-beta.1", ChannelRC}},
+		{"1.9.0", "2.0.0-alpha", ChannelBeta, nil},
+		{"2.0.0-rc1", "2.0.0", ChannelStable, nil},
+		{"2.0.0-rc1", "2.0.0-rc2", ChannelRC, nil},
+	}
+	for _, tt := range tests {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: "github", Version: tt.installed},
+			&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: tt.next}},
+			nil, "5.18.0", ReleaseChannelUpdateOpOption(tt.channel))
+		require.NoError(t, err)
+		require.Equal(t, tt.err, updateOp.CanBeUpdated(), "%s to %s in %s channel", tt.installed, tt.next, tt.channel)
+	}
+}
+
+func TestCanBeUpdatedSoak(t *testing.T) {
+	day := 24 * time.Hour
+	recent := time.Now().Add(-2 * day)
+	tests := []struct {
+		releasedAt time.Time
+		minAge     time.Duration
+		err        error
+	}{
+		{recent, 0, nil},
+		{recent, day, nil},
+		{recent, 3 * day, &SoakError{"github", "1.3.0", recent, recent.Add(3 * day)}},
+	}
+	for _, tt := range tests {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: "github", Version: "1.2.3"},
+			&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: "1.3.0"}},
+			nil, "5.18.0", SoakUpdateOpOption(tt.releasedAt, tt.minAge))
+		require.NoError(t, err)
+		require.Equal(t, tt.err, updateOp.CanBeUpdated(), "min release age %s", tt.minAge)
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	plugins := marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: "2.0.0-rc1"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: "1.9.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "github", Version: "2.1.0-beta.1"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "3.0.0-rc1"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "latest"}}},
+	}
+	tests := []struct {
+		id      string
+		channel ReleaseChannel
+		version string
+		err     error
+	}{
+		{"github", ChannelStable, "1.9.0", nil},
+		{"github", ChannelRC, "2.0.0-rc1", nil},
+		{"github", ChannelBeta, "2.1.0-beta.1", nil},
+		{"jira", ChannelStable, "", ErrNoNewerVersion},
+		{"zoom", ChannelStable, "latest", nil},
+		{"todo", ChannelStable, "", &marketplace.NotFoundError{ID: "todo"}},
+	}
+	for _, tt := range tests {
+		latest, err := latestRelease(plugins, tt.id, tt.channel.allows)
+		require.Equal(t, tt.err, err)
+		if tt.err == nil {
+			require.Equal(t, tt.version, latest.Manifest.Version, "%s in %s channel", tt.id, tt.channel)
+		}
+	}
+}
+
+func TestParsePluginReleaseChannels(t *testing.T) {
+	channels, err := ParsePluginReleaseChannels("github: beta, jira:rc")
+	require.NoError(t, err)
+	require.Equal(t, map[string]ReleaseChannel{"github": ChannelBeta, "jira": ChannelRC}, channels)
+
+	_, err = ParsePluginReleaseChannels("github:nightly")
+	require.Equal(t, `unknown release channel "nightly"`, err.Error())
+}
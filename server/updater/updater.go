@@ -5,22 +5,36 @@ package updater
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
 	dlock "github.com/ilgooz/mattermost-dlock"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xtime"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
 )
 
 const (
 	// defaultUpdateInterval used as a default wait time to wait before checking for updates again.
 	defaultUpdateInterval = time.Minute * 3
 
+	// defaultConcurrency used as a default max number of updates that can be made at the same time.
+	defaultConcurrency = 4
+
+	// defaultUpdateTimeout used as a default max time that an update can take.
+	defaultUpdateTimeout = time.Minute * 60
+
 	// updateLockKey used to do enable a distributed lock while doing update checks and updates.
 	updateLockKey = "marketplace-addon:updater"
+
+	// pluginLockKeyPrefix used to prefix distributed lock keys that prevent updating the same
+	// plugin concurrently by update checks and manual updates.
+	pluginLockKeyPrefix = "marketplace-addon:updater:"
 )
 
 // Updater constantly updates plugins installed to the Mattermost Server to the latest versions.
@@ -31,17 +45,47 @@ type Updater struct {
 	// dlockStore used by the distributed lock to keep synchronization states.
 	dlockStore dlock.Store
 
-	// notifications chan forwards notifications related to plugin updates or failed update attempts.
-	notifications chan Notification
+	// notifications fans out notifications related to plugin updates or failed update attempts
+	// to subscribers.
+	notifications *dispatcher
+
+	// events fans out lifecycle events to subscribers.
+	events *dispatcher
 
 	mc sync.RWMutex // protects config.
 	// config holds configs set as options.
 	conf *config
 
+	ma sync.Mutex // protects announced, planned, reported, backupFailed, queued and marketplaceError.
+	// announced keeps the latest version of plugins that are announced as available but
+	// blocked by the update policy, so they are only announced once.
+	announced map[string]string
+	// planned keeps the versions of plugins that are notified as planned in dry-run mode, so
+	// they are only notified once and still announced once dry-run mode is turned off.
+	planned map[string]string
+	// reported keeps the versions and kinds of the errors that are notified per plugin, so the
+	// same error is only notified once for a version.
+	reported map[string]string
+	// backupFailed keeps the versions of plugins that are notified to be installed without a
+	// backup, so they are only notified once.
+	backupFailed map[string]string
+	// queued keeps the versions of plugins that are queued to be installed in the next
+	// maintenance window, so they are only notified once.
+	queued map[string]string
+	// marketplaceError keeps the last notified error of the Marketplace API so it's only
+	// notified once until plugins are listed again.
+	marketplaceError string
+
 	// stopPooling stops poolling(checking for updates) -which means, it cancels Start().
 	stopPooling context.CancelFunc
 	// stopWait used to wait for stop proccess to be completed.
 	stopWait *sync.WaitGroup
+
+	mj sync.Mutex // protects stopped.
+	// stopped is set when Start() returns to not accept new jobs.
+	stopped bool
+	// jobs used to wait for manually started update checks and updates to be completed.
+	jobs sync.WaitGroup
 }
 
 // Marketplace used to fetch latest versions of plugins from Mattermost Marketplace.
@@ -62,19 +106,85 @@ type config struct {
 
 	// skipPlugins is a list of plugins(ids) to be skipped during the update check.
 	skipPlugins []string
+
+	// holds are the holds set by the config, they're overwritten by the holds set at runtime.
+	holds []Hold
+
+	// policy is the update policy of plugins that has no policy set in pluginPolicies.
+	policy UpdatePolicy
+
+	// pluginPolicies keeps update policies per plugin(id).
+	pluginPolicies map[string]UpdatePolicy
+
+	// channel is the release channel of plugins that has no channel set in pluginChannels.
+	channel ReleaseChannel
+
+	// pluginChannels keeps release channels per plugin(id).
+	pluginChannels map[string]ReleaseChannel
+
+	// approvalMode defines which plugins are only updated after an approval.
+	approvalMode ApprovalMode
+
+	// approvalPlugins is a list of plugins(ids) that require an approval in ApprovalSelected mode.
+	approvalPlugins []string
+
+	// minReleaseAge is the min time that should pass after a version is released before
+	// updating to it.
+	minReleaseAge time.Duration
+
+	// dryRun enables reporting planned updates without installing them.
+	dryRun bool
+
+	// maintenanceWindows restricts installing updates to these time windows.
+	// updates can be installed anytime when it is empty.
+	maintenanceWindows []xtime.Window
+
+	// concurrency is the max number of updates that can be made at the same time.
+	concurrency int
+
+	// updateTimeout is the max time that downloading and installing an update can take.
+	updateTimeout time.Duration
+
+	// transport used to download plugin bundles.
+	transport http.RoundTripper
+
+	// maxBundleSize is the max size of a downloaded plugin bundle in bytes.
+	maxBundleSize int64
+
+	// retryPolicy defines how failed Marketplace listings and bundle downloads are retried.
+	retryPolicy RetryPolicy
+
+	// verificationMode defines how downloaded bundles are verified before they're installed.
+	verificationMode VerificationMode
+
+	// publicKeys used to verify signatures of downloaded bundles.
+	publicKeys openpgp.EntityList
+
+	// healthCheckTimeout is the max time to wait for an updated plugin to run before rolling
+	// it back to its previous version.
+	healthCheckTimeout time.Duration
 }
 
 // New creates new Updater with papi, marketplace, dlockStore and other options.
 func New(papi plugin.API, marketplace Marketplace, dlockStore dlock.Store, options ...Option) *Updater {
 	u := &Updater{
-		papi:       papi,
-		dlockStore: dlockStore,
-		conf:       &config{marketplace: marketplace},
-		stopWait:   &sync.WaitGroup{},
+		papi:          papi,
+		dlockStore:    dlockStore,
+		conf:          &config{marketplace: marketplace},
+		announced:     make(map[string]string),
+		planned:       make(map[string]string),
+		reported:      make(map[string]string),
+		backupFailed:  make(map[string]string),
+		queued:        make(map[string]string),
+		stopWait:      &sync.WaitGroup{},
+		notifications: newDispatcher(),
+		events:        newDispatcher(),
 	}
 	u.UpdateConfig(options...)
-	// save notification chan at the beginning, so it cannot bu updated later by the UpdateConfig().
-	u.notifications = u.conf.notifications
+	// subscribe notification chan at the beginning, so it cannot bu updated later by the UpdateConfig().
+	if u.conf.notifications != nil {
+		subscribeNotifications(u.notifications, u.conf.notifications, BlockPolicy)
+	}
 	return u
 }
 
@@ -88,6 +198,48 @@ func (u *Updater) UpdateConfig(options ...Option) {
 	if u.conf.updateInterval == 0 {
 		u.conf.updateInterval = defaultUpdateInterval
 	}
+	if u.conf.policy == "" {
+		u.conf.policy = PolicyMinor
+	}
+	if u.conf.channel == "" {
+		u.conf.channel = ChannelStable
+	}
+	if u.conf.concurrency <= 0 {
+		u.conf.concurrency = defaultConcurrency
+	}
+	if u.conf.updateTimeout == 0 {
+		u.conf.updateTimeout = defaultUpdateTimeout
+	}
+	if u.conf.healthCheckTimeout == 0 {
+		u.conf.healthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if u.conf.retryPolicy.Attempts == 0 {
+		u.conf.retryPolicy.Attempts = defaultRetryPolicy.Attempts
+	}
+	if u.conf.retryPolicy.BaseDelay == 0 {
+		u.conf.retryPolicy.BaseDelay = defaultRetryPolicy.BaseDelay
+	}
+	if u.conf.retryPolicy.MaxDelay == 0 {
+		u.conf.retryPolicy.MaxDelay = defaultRetryPolicy.MaxDelay
+	}
+}
+
+// downloader creates a downloader for plugin bundles with conf and options.
+func (u *Updater) downloader(conf config, options ...xplugin.DownloaderOption) *xplugin.Downloader {
+	options = append(options, xplugin.MaxBundleSizeOption(conf.maxBundleSize))
+	if conf.transport != nil {
+		options = append(options, xplugin.HTTPClientOption(&http.Client{Transport: conf.transport}))
+	}
+	// only the bundles of local Marketplaces can be downloaded from the file system.
+	if local, ok := conf.marketplace.(interface{ LocalDirs() []string }); ok {
+		options = append(options, xplugin.LocalDirsOption(local.LocalDirs()...))
+	}
+	return xplugin.NewDownloader(options...)
+}
+
+// Marketplace returns the Marketplace that plugins are updated from.
+func (u *Updater) Marketplace() Marketplace {
+	return u.cloneConfing().marketplace
 }
 
 // cloneConfing gets a snapshot of config's current state.
@@ -121,7 +273,136 @@ func SkipPluginsOption(ids []string) Option {
 	}
 }
 
-// NotificationsOption sets a notification chan to receive update related notifications.
+// HoldsOption holds updates of plugins with holds. holds set at runtime by Updater.Hold overwrite
+// these for the same plugins. held updates are not installed or notified and they're reported with
+// a *HoldError by Status and Plan.
+func HoldsOption(holds []Hold) Option {
+	return func(u *Updater) {
+		u.conf.holds = holds
+	}
+}
+
+// UpdatePolicyOption sets an update policy for all plugins and overwrites it for some plugins
+// with pluginPolicies where keys are plugin ids. PolicyMinor is used by default.
+// updates blocked by the policy are not installed, instead they're announced once with an
+// UpdatePolicyError notification.
+func UpdatePolicyOption(policy UpdatePolicy, pluginPolicies map[string]UpdatePolicy) Option {
+	return func(u *Updater) {
+		u.conf.policy = policy
+		u.conf.pluginPolicies = pluginPolicies
+	}
+}
+
+// ReleaseChannelOption sets a release channel for all plugins and overwrites it for some plugins
+// with pluginChannels where keys are plugin ids. plugins are only updated to the versions that are
+// released in their channels. ChannelStable is used by default.
+func ReleaseChannelOption(channel ReleaseChannel, pluginChannels map[string]ReleaseChannel) Option {
+	return func(u *Updater) {
+		u.conf.channel = channel
+		u.conf.pluginChannels = pluginChannels
+	}
+}
+
+// ApprovalOption sets which plugins are only updated after an approval. pluginIDs is only used
+// with ApprovalSelected mode. updates found by the update checks wait until they're approved by
+// Approve and the approval is requested once per version with a notification. approvals of older
+// versions expire once a newer version is found.
+func ApprovalOption(mode ApprovalMode, pluginIDs []string) Option {
+	return func(u *Updater) {
+		u.conf.approvalMode = mode
+		u.conf.approvalPlugins = pluginIDs
+	}
+}
+
+// MinReleaseAgeOption sets the min time that should pass after a version of a plugin is released
+// before updating to it. the release time published by the Marketplace is used when available,
+// otherwise the time that the version is first seen by the updater is used.
+// updates that are soaking are not installed or notified and they're reported with a *SoakError
+// by Status and Plan.
+func MinReleaseAgeOption(age time.Duration) Option {
+	return func(u *Updater) {
+		u.conf.minReleaseAge = age
+	}
+}
+
+// ConcurrencyOption sets the max number of updates that can be made at the same time.
+func ConcurrencyOption(n int) Option {
+	return func(u *Updater) {
+		u.conf.concurrency = n
+	}
+}
+
+// UpdateTimeoutOption sets the max time that downloading and installing an update can take.
+// timed out updates are notified with a *TimeoutError.
+func UpdateTimeoutOption(timeout time.Duration) Option {
+	return func(u *Updater) {
+		u.conf.updateTimeout = timeout
+	}
+}
+
+// RetryOption sets a policy to retry failed Marketplace listings and bundle downloads.
+// failures that cannot succeed on retry, like invalid versions or client errors, are not retried.
+func RetryOption(policy RetryPolicy) Option {
+	return func(u *Updater) {
+		u.conf.retryPolicy = policy
+	}
+}
+
+// TransportOption sets the HTTP transport used to download plugin bundles.
+func TransportOption(transport http.RoundTripper) Option {
+	return func(u *Updater) {
+		u.conf.transport = transport
+	}
+}
+
+// MaxBundleSizeOption sets the max size of a downloaded plugin bundle in bytes.
+// larger bundles are rejected with a *xplugin.InvalidBundleError before they're installed.
+func MaxBundleSizeOption(size int64) Option {
+	return func(u *Updater) {
+		u.conf.maxBundleSize = size
+	}
+}
+
+// VerificationOption sets how downloaded bundles are verified before they're installed.
+// bundles are verified against their detached GPG signatures signed with one of publicKeys and
+// SHA-256 digests, both are published next to bundles in the Marketplace with ".sig" and ".sha256"
+// extensions. rejected bundles are notified with a *VerificationError.
+func VerificationOption(mode VerificationMode, publicKeys openpgp.EntityList) Option {
+	return func(u *Updater) {
+		u.conf.verificationMode = mode
+		u.conf.publicKeys = publicKeys
+	}
+}
+
+// HealthCheckTimeoutOption sets the max time to wait for an updated plugin to run.
+// plugins that were running before the update and not running after the timeout are rolled back
+// to their previous versions.
+func HealthCheckTimeoutOption(timeout time.Duration) Option {
+	return func(u *Updater) {
+		u.conf.healthCheckTimeout = timeout
+	}
+}
+
+// DryRunOption enables dry-run mode when enabled is true. in dry-run mode, update checks send
+// a notification for every planned update with the reason if it would not be made but never
+// install any plugins. every planned update is only notified once per version.
+func DryRunOption(enabled bool) Option {
+	return func(u *Updater) {
+		u.conf.dryRun = enabled
+	}
+}
+
+// MaintenanceWindowsOption restricts installing updates to windows. update checks keep running on
+// the update interval but found updates are queued until one of the windows opens.
+// queued updates are notified once per version.
+func MaintenanceWindowsOption(windows []xtime.Window) Option {
+	return func(u *Updater) {
+		u.conf.maintenanceWindows = windows
+	}
+}
+
+// NotificationsOption subscribes a notification chan to receive update related notifications
+// with BlockPolicy. use SubscribeNotifications to subscribe with a buffer and a delivery policy.
 // these notifications sent for every successful and unsuccessful updates and any errors
 // occurred during an update process.
 // notifications needs to be consumed within separate goroutines in order to not block the updater.
@@ -141,9 +422,14 @@ func (u *Updater) Start() error {
 	u.stopWait.Add(1)
 	defer func() {
 		defer u.stopWait.Done()
-		if u.notifications != nil {
-			close(u.notifications)
-		}
+		// wait for manual jobs before closing the notifications chan and events since they might
+		// be still sending notifications and events.
+		u.mj.Lock()
+		u.stopped = true
+		u.mj.Unlock()
+		u.jobs.Wait()
+		u.notifications.close()
+		u.events.close()
 	}()
 	// create a cancelable context to stop pooling later.
 	var ctx context.Context
@@ -185,98 +471,441 @@ func (u *Updater) Start() error {
 
 // checkAndUpdate checks for new versions of installed plugins and updates them accordingly.
 func (u *Updater) checkAndUpdate() {
+	if u.cloneConfing().dryRun {
+		u.plan()
+		return
+	}
+	r := &round{id: model.NewId()}
+	u.emit(Event{Type: EventCheckStarted, CheckID: r.id})
+	defer func() { u.emit(r.finished()) }()
 	u.papi.LogInfo("checking for new versions...")
-	updates := u.discover()
+	updates := u.discover(r)
 	lenUpdates := len(updates)
 	if lenUpdates == 0 {
 		u.papi.LogInfo("no new versions found")
 		return
 	}
 	u.papi.LogInfo(fmt.Sprintf("found %d plugins to update", lenUpdates))
+	// only install updates in a maintenance window.
+	if next := u.nextMaintenanceWindow(time.Now()); !next.IsZero() {
+		u.papi.LogInfo(fmt.Sprintf("queued updates until the next maintenance window at %s", next))
+		r.count(func(c *CheckCounts) { c.Queued = lenUpdates })
+		for _, updateOp := range updates {
+			u.emitUpdate(EventUpdateQueued, updateOp, Event{})
+			if u.queue(updateOp.installed.Id, updateOp.next.Manifest.Version) {
+				u.notifyQueued(updateOp, next)
+			}
+		}
+		return
+	}
+	u.clearQueue()
 	var wg sync.WaitGroup
 	wg.Add(lenUpdates)
-	// TODO(ilgooz): limit the number of how many goroutines can be created.
+	// limit the number of updates that can be made at the same time.
+	workers := make(chan struct{}, u.cloneConfing().concurrency)
 	for _, updateOp := range updates {
+		workers <- struct{}{}
 		go func(updateOp *UpdateOp) {
-			defer wg.Done()
-			u.papi.LogInfo(fmt.Sprintf("updating %q from %q to %q...", updateOp.installed.Id,
-				updateOp.installed.Version, updateOp.next.Manifest.Version))
-			u.update(updateOp)
-			u.papi.LogInfo(fmt.Sprintf("updated %q", updateOp.installed.Id))
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			id := updateOp.installed.Id
+			installedVersion, nextVersion := updateOp.installed.Version, updateOp.next.Manifest.Version
+			// a manual update might be in progress for the same plugin.
+			dl, err := u.lockPlugin(id)
+			if err != nil {
+				u.papi.LogInfo(fmt.Sprintf("skipping %q: %s", id, err))
+				u.skip(r, id, installedVersion, nextVersion, err.Error())
+				return
+			}
+			defer dl.Unlock()
+			// the plugin might be already updated by a manual update.
+			if installed, err := u.isInstalled(updateOp.installed); err != nil || !installed {
+				u.papi.LogInfo(fmt.Sprintf("skipping %q: plugin is changed since the update check", id))
+				u.skip(r, id, installedVersion, nextVersion, "plugin is changed since the update check")
+				return
+			}
+			u.papi.LogInfo(fmt.Sprintf("updating %q from %q to %q...", id, installedVersion, nextVersion))
+			if err := u.update(updateOp); err != nil {
+				r.count(func(c *CheckCounts) { c.Failed++ })
+				return
+			}
+			r.count(func(c *CheckCounts) { c.Installed++ })
+			u.papi.LogInfo(fmt.Sprintf("updated %q", id))
 		}(updateOp)
 	}
 	wg.Wait()
 }
 
-// discover discovers plugins that can be updated an returns a list of them.
-func (u *Updater) discover() []*UpdateOp {
-	var updates []*UpdateOp
-	// get a list of installed plugins.
-	installedPlugins, aerr := u.papi.GetPlugins()
+// nextMaintenanceWindow returns the opening time of the next maintenance window after t.
+// it returns with the zero time when updates can be installed at t.
+func (u *Updater) nextMaintenanceWindow(t time.Time) time.Time {
+	conf := u.cloneConfing()
+	if len(conf.maintenanceWindows) == 0 {
+		return time.Time{}
+	}
+	var next time.Time
+	for _, w := range conf.maintenanceWindows {
+		if w.Contains(t) {
+			return time.Time{}
+		}
+		if opening := w.Next(t); next.IsZero() || opening.Before(next) {
+			next = opening
+		}
+	}
+	return next
+}
+
+// queue marks version of plugin with id as queued and reports if it was not queued before.
+func (u *Updater) queue(id, version string) bool {
+	u.ma.Lock()
+	defer u.ma.Unlock()
+	if u.queued[id] == version {
+		return false
+	}
+	u.queued[id] = version
+	return true
+}
+
+// clearQueue clears queued updates once they're about to be installed.
+func (u *Updater) clearQueue() {
+	u.ma.Lock()
+	defer u.ma.Unlock()
+	u.queued = make(map[string]string)
+}
+
+// plan notifies about the updates that would be made without installing them.
+func (u *Updater) plan() {
+	u.papi.LogInfo("planning updates in dry-run mode...")
+	plan, err := u.Plan(context.Background())
+	if err != nil {
+		u.papi.LogError(err.Error())
+		return
+	}
+	u.papi.LogInfo(fmt.Sprintf("found %d planned updates", len(plan)))
+	for _, status := range plan {
+		if u.markPlanned(status.PluginID, status.LatestVersion) {
+			u.notifyPlanned(status)
+		}
+	}
+}
+
+// lockPlugin obtains a distributed lock for updating plugin with id.
+// it returns with ErrUpdateInProgress if the plugin is already being updated.
+func (u *Updater) lockPlugin(id string) (*dlock.DLock, error) {
+	dl := dlock.New(pluginLockKeyPrefix+id, u.dlockStore)
+	if err := dl.Lock(dlock.ObtainImmediatelyOption()); err != nil {
+		if err == dlock.ErrCouldntObtainImmediately {
+			return nil, ErrUpdateInProgress
+		}
+		return nil, err
+	}
+	return dl, nil
+}
+
+// isInstalled checks if the plugin with manifest is still installed with the same version.
+func (u *Updater) isInstalled(manifest *model.Manifest) (bool, error) {
+	plugins, aerr := u.papi.GetPlugins()
 	if aerr != nil {
-		u.papi.LogInfo(errors.Wrap(aerr, "cannot get a list of installed plugins").Error())
-		return nil
+		return false, errors.Wrap(aerr, "cannot get a list of installed plugins")
 	}
-	u.papi.LogInfo(fmt.Sprintf("found %d installed plugins", len(installedPlugins)))
-	// if there are no installed plugins, there is nothing to update.
-	if len(installedPlugins) == 0 {
-		return nil
+	for _, plugin := range plugins {
+		if plugin.Id == manifest.Id {
+			return plugin.Version == manifest.Version, nil
+		}
 	}
-	// get a list of Marketplace plugins.
-	marketplacePlugins, err := u.conf.marketplace.ListPlugins()
+	return false, nil
+}
+
+// discover discovers plugins that can be updated in round r an returns a list of them.
+func (u *Updater) discover(r *round) []*UpdateOp {
+	conf := u.cloneConfing()
+	candidates, err := u.candidates(context.Background())
 	if err != nil {
-		u.papi.LogError(errors.Wrap(err, "cannot get a list of plugins from Marketplace").Error())
+		r.err = err
+		u.reportListingError(err)
 		return nil
 	}
-	u.papi.LogInfo(fmt.Sprintf("found %d plugins in the marketplace", len(marketplacePlugins)))
-	serverVersion := u.papi.GetServerVersion()
-	// check every installed plugin to see if there is new versions.
-	for _, manifest := range installedPlugins {
-		// get the last version of the installed plugin from the Marketplace.
-		// do nothing if the plugin is not in the Marketplace.
-		marketplacePlugin, err := marketplacePlugins.GetPlugin(manifest.Id)
-		if err != nil {
-			u.papi.LogError(err.Error())
-			continue
-		}
-		// create a new update operation for installed plugin and its version in the marketplace.
-		updateOp, err := NewUpdateOp(manifest, marketplacePlugin, u.conf.skipPlugins, serverVersion)
-		if err != nil {
-			u.notifyError(manifest.Id, err)
-			continue
+	u.ma.Lock()
+	u.marketplaceError = ""
+	u.ma.Unlock()
+	r.count(func(c *CheckCounts) { c.Checked = len(candidates) })
+	var updates []*UpdateOp
+	for _, c := range candidates {
+		var version string
+		if c.latest != nil {
+			version = c.latest.Manifest.Version
 		}
 		// check if the plugin we get from the Marketplace is appropriate to replace the installed one.
 		// if so add it to the updates list.
-		if err := updateOp.CanBeUpdated(); err != nil {
-			switch err {
-			case ErrNoNewerVersion, ErrPluginInSkipList:
-			default:
-				u.notifyError(manifest.Id, err)
+		switch e := c.err.(type) {
+		case nil:
+			c.updateOp.checkID = r.id
+			if u.awaitApproval(conf, c.updateOp) {
+				u.skip(r, c.installed.Id, c.installed.Version, version, "waiting for an approval")
+				continue
 			}
+			updates = append(updates, c.updateOp)
+			continue
+		case *marketplace.NotFoundError:
+			// do nothing if the plugin is not in the Marketplace.
+			u.papi.LogError(e.Error())
 			continue
+		case *UpdatePolicyError:
+			if u.announce(e.PluginID, e.NextPluginVersion) {
+				u.notifyError(c.installed.Id, e)
+			}
+		case *SoakError:
+			// the update will be installed once it's soaked.
+			u.papi.LogInfo(e.Error())
+		case *HoldError:
+			u.papi.LogInfo(e.Error())
+		case *RolledBackVersionError:
+			u.papi.LogInfo(e.Error())
+		default:
+			if e == ErrNoNewerVersion {
+				continue
+			}
+			// the same error is found on every check until the plugin or the Marketplace changes,
+			// so it is only notified once.
+			if e != ErrPluginInSkipList && u.report(c.installed.Id, version, e) {
+				u.notifyError(c.installed.Id, e)
+			}
 		}
-		updates = append(updates, updateOp)
+		u.skip(r, c.installed.Id, c.installed.Version, version, c.err.Error())
 	}
+	r.count(func(c *CheckCounts) { c.Updates = len(updates) })
 	return updates
 }
 
-// update updates an installed plugin by using info from updateOp.
-func (u *Updater) update(updateOp *UpdateOp) {
-	// install the plugin.
-	_, aerr := xplugin.InstallPluginFromURL(u.papi, updateOp.next.DownloadURL, true)
-	if aerr != nil {
-		u.notifyError(updateOp.installed.Id, errors.Wrap(aerr, "could not install the plugin"))
+// pluginChannel returns the release channel of plugin with id.
+func (c config) pluginChannel(id string) ReleaseChannel {
+	if channel, ok := c.pluginChannels[id]; ok {
+		return channel
+	}
+	return c.channel
+}
+
+// UpdatePolicies returns the update policy of all plugins and the policies that overwrite it per
+// plugin(id).
+func (u *Updater) UpdatePolicies() (UpdatePolicy, map[string]UpdatePolicy) {
+	conf := u.cloneConfing()
+	pluginPolicies := make(map[string]UpdatePolicy, len(conf.pluginPolicies))
+	for id, policy := range conf.pluginPolicies {
+		pluginPolicies[id] = policy
+	}
+	return conf.policy, pluginPolicies
+}
+
+// pluginPolicy returns the update policy of plugin with id.
+func (c config) pluginPolicy(id string) UpdatePolicy {
+	if policy, ok := c.pluginPolicies[id]; ok {
+		return policy
+	}
+	return c.policy
+}
+
+// reportListingError reports an error of listing plugins.
+// an unavailable Marketplace is only logged since listing is retried on the next check but
+// requests that are rejected by the Marketplace need an action from admins, so they're also
+// notified once until plugins are listed again.
+func (u *Updater) reportListingError(err error) {
+	e, ok := errors.Cause(err).(*marketplace.APIError)
+	if !ok {
+		u.papi.LogError(err.Error())
+		return
+	}
+	if e.Retryable() {
+		u.papi.LogWarn(errors.Wrap(err, "Marketplace is unavailable, will try again on the next check").Error())
 		return
 	}
+	u.papi.LogError(err.Error())
+	u.ma.Lock()
+	announced := u.marketplaceError == e.Error()
+	u.marketplaceError = e.Error()
+	u.ma.Unlock()
+	if !announced {
+		u.notifyError("", err)
+	}
+}
+
+// announce marks version of plugin with id as announced and reports if it was not
+// announced before.
+func (u *Updater) announce(id, version string) bool {
+	return u.markVersion(u.announced, id, version)
+}
+
+// markPlanned marks version of plugin with id as planned and reports if it was not
+// planned before.
+func (u *Updater) markPlanned(id, version string) bool {
+	return u.markVersion(u.planned, id, version)
+}
+
+// markVersion sets version of plugin with id in versions and reports if it was not set before.
+func (u *Updater) markVersion(versions map[string]string, id, version string) bool {
+	u.ma.Lock()
+	defer u.ma.Unlock()
+	if versions[id] == version {
+		return false
+	}
+	versions[id] = version
+	return true
+}
+
+// report marks err about version of plugin with id as reported and reports if it was not
+// reported before. errors are compared by their kinds since their messages might change
+// between attempts.
+func (u *Updater) report(id, version string, err error) bool {
+	key := fmt.Sprintf("%s %T", version, errors.Cause(err))
+	u.ma.Lock()
+	defer u.ma.Unlock()
+	if reported, ok := u.reported[id]; ok && reported == key {
+		return false
+	}
+	u.reported[id] = key
+	return true
+}
+
+// clearReported forgets the reported error of plugin with id once it is updated.
+func (u *Updater) clearReported(id string) {
+	u.ma.Lock()
+	defer u.ma.Unlock()
+	delete(u.reported, id)
+}
+
+// update updates an installed plugin by using info from updateOp, records the update attempt to
+// the history and notifies about it. it returns the error of a failed update.
+func (u *Updater) update(updateOp *UpdateOp) error {
+	timeout := u.cloneConfing().updateTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := u.install(ctx, updateOp)
+	// a plugin might be rolled back after the deadline since the health checks are not cancelled,
+	// keep the rollback error so the failed version is not installed again.
+	if _, rolledBack := err.(*RollbackError); err != nil && !rolledBack && ctx.Err() == context.DeadlineExceeded {
+		err = &TimeoutError{
+			PluginID:          updateOp.installed.Id,
+			NextPluginVersion: updateOp.next.Manifest.Version,
+			Timeout:           timeout,
+			Err:               err,
+		}
+	}
+	u.record(updateOp, err)
+	if err != nil {
+		if e, ok := err.(*RollbackError); ok {
+			u.markRolledBack(e.PluginID, e.FailedPluginVersion)
+			u.emitUpdate(EventRolledBack, updateOp, Event{Err: e.Err})
+		}
+		u.emitUpdate(EventUpdateFailed, updateOp, Event{Err: err})
+		// the update is attempted again on the next check, only notify about a new failure.
+		if u.report(updateOp.installed.Id, updateOp.next.Manifest.Version, err) {
+			u.notifyError(updateOp.installed.Id, err)
+		}
+		return err
+	}
+	u.emitUpdate(EventInstalled, updateOp, Event{})
+	u.clearReported(updateOp.installed.Id)
+	u.clearRolledBack(updateOp.installed.Id)
+	if updateOp.minReleaseAge > 0 {
+		u.deleteFirstSeen(updateOp.installed.Id, updateOp.next.Manifest.Version)
+	}
+	if updateOp.approvedBy != "" {
+		u.deleteApproval(updateOp.installed.Id)
+	}
 	// create a changelog about the update.
 	changelog := updateOp.CreateChangelog()
 	// notify about the update.
 	u.notifyUpdated(updateOp.installed.Id, changelog)
+	return nil
+}
+
+// download downloads the bundle of the next plugin in updateOp with conf.
+// the download is cancelled when ctx is done.
+func (u *Updater) download(ctx context.Context, conf config, updateOp *UpdateOp) (*xplugin.Bundle, error) {
+	downloader := u.downloader(conf, xplugin.ProgressOption(u.downloadProgress(updateOp)))
+	var bundle *xplugin.Bundle
+	err := u.retry(ctx, fmt.Sprintf("downloading %q", updateOp.next.DownloadURL), func() (err error) {
+		u.emitUpdate(EventDownloadStarted, updateOp, Event{})
+		bundle, err = downloader.Download(ctx, updateOp.next.DownloadURL, updateOp.installed.Id)
+		if err != nil {
+			u.emitUpdate(EventDownloadFinished, updateOp, Event{Err: err})
+			return err
+		}
+		u.emitUpdate(EventDownloadFinished, updateOp, Event{BytesRead: bundle.Size, BytesTotal: bundle.Size})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not download the plugin")
+	}
+	return bundle, nil
+}
+
+// install installs the next version of the plugin by using info from updateOp.
+// if the plugin was running before the update and the new version fails to run, it is rolled back
+// to its previous version and a *RollbackError is returned.
+// backing up the installed plugin and downloading the update are cancelled when ctx is done.
+func (u *Updater) install(ctx context.Context, updateOp *UpdateOp) error {
+	conf := u.cloneConfing()
+	id := updateOp.installed.Id
+	// only plugins that were running are expected to be running after the update.
+	wasRunning, err := u.isRunning(id)
+	if err != nil {
+		u.papi.LogError(errors.Wrap(err, "health checks are disabled for the update").Error())
+	}
+	// keep the installed bundle to roll back when the new version fails to run.
+	backedUp := false
+	if wasRunning {
+		if err := u.backup(ctx, id); err != nil {
+			// the update cannot be completed in time either.
+			if ctx.Err() != nil {
+				return errors.Wrap(err, "could not back up the plugin")
+			}
+			err = &BackupError{PluginID: id, NextPluginVersion: updateOp.next.Manifest.Version, Err: err}
+			u.papi.LogError(err.Error())
+			if u.markVersion(u.backupFailed, id, updateOp.next.Manifest.Version) {
+				u.notifyError(id, err)
+			}
+		} else {
+			backedUp = true
+			defer u.deleteBackup(id)
+		}
+	}
+	// download and install the plugin.
+	bundle, err := u.download(ctx, conf, updateOp)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+	if err := u.verify(ctx, updateOp, bundle); err != nil {
+		return err
+	}
+	r, err := bundle.Reader()
+	if err != nil {
+		return errors.Wrap(err, "could not read the plugin")
+	}
+	if _, aerr := u.papi.InstallPlugin(r, true); aerr != nil {
+		return errors.Wrap(aerr, "could not install the plugin")
+	}
+	// check the health of the new version.
+	if !wasRunning {
+		return nil
+	}
+	if err := u.waitRunning(id, conf.healthCheckTimeout); err != nil {
+		if !backedUp {
+			return errors.Wrapf(err, "%q version is not healthy and cannot be rolled back",
+				updateOp.next.Manifest.Version)
+		}
+		return u.rollback(updateOp, err)
+	}
+	return nil
 }
 
 // Stop stops checking for updates and immediately returns.
-// it does not interrupt current update process if there is any.
+// it does not interrupt current update process if there is any but its notifications are dropped
+// for the subscribers that do not have room for them.
 func (u *Updater) Stop() error {
+	u.notifications.release()
 	if u.stopPooling != nil {
 		u.stopPooling()
 		u.stopPooling = nil
@@ -1,12 +1,22 @@
 package updater
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -14,8 +24,11 @@ import (
 	"github.com/ilgooz/mattermost-dlock/dlocktest"
 	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/marketplace"
 	updatermock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/updater/mocks"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
 	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xtime"
 	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -27,6 +40,8 @@ func TestUpdate(t *testing.T) {
 	defer ts.Close()
 
 	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
 	apiMock.On("GetPlugins").Return([]*model.Manifest{
 		{
 			Id:      "topdf", // should update only topdf plugin.
@@ -45,17 +60,21 @@ func TestUpdate(t *testing.T) {
 	apiMock.On("LogInfo", `updated "topdf"`).Once()
 	apiMock.On("LogError", (&marketplace.NotFoundError{ID: "github"}).Error()).Once()
 	apiMock.On("GetServerVersion").Return("5.4.0")
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateRunning}, nil)
+	apiMock.On("GetBundlePath").Return("testdata/plugins/marketplace-addon", nil)
+	backup := mockBackup(apiMock)
+	history := mockHistory(apiMock)
 	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil).Run(func(args mock.Arguments) {
 		tar := args.Get(0).(io.Reader)
 		data, err := ioutil.ReadAll(tar)
 		require.NoError(t, err)
-		require.Equal(t, "topdf-0.1.3", string(data))
+		require.Equal(t, readTestdata(t, "topdf-1.3.0.tar.gz"), data)
 	})
 
 	marketplaceMock := &updatermock.Marketplace{}
 	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
-		{
-			DownloadURL: buildDownloadURL(ts.URL, "topdf-0.1.3"),
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+			DownloadURL: buildDownloadURL(ts.URL, "topdf-1.3.0.tar.gz"),
 			Manifest: &model.Manifest{
 				Id:               "topdf",
 				Version:          "1.3.0",
@@ -63,8 +82,8 @@ func TestUpdate(t *testing.T) {
 				Name:             "TOPDF",
 				Description:      "Create PDFs to preview Office files!",
 			},
-		},
-		{
+		}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
 			DownloadURL: buildDownloadURL(ts.URL, "topdf-1.5.1"),
 			Manifest: &model.Manifest{
 				Id:               "antivirus",
@@ -73,7 +92,7 @@ func TestUpdate(t *testing.T) {
 				Name:             "Antivirus",
 				Description:      "Scan attachments agains viruses!",
 			},
-		},
+		}},
 	}, nil)
 
 	notifications := make(chan Notification)
@@ -108,12 +127,863 @@ func TestUpdate(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, startErr)
 
+	require.Len(t, *history, 1)
+	require.Equal(t, "topdf", (*history)[0].PluginID)
+	require.Equal(t, OutcomeUpdated, (*history)[0].Outcome)
+	// the backup is deleted once the update is made.
+	require.Equal(t, 2, backup.saved)
+	require.Empty(t, backup.values)
+
 	apiMock.AssertExpectations(t)
 	marketplaceMock.AssertExpectations(t)
 }
 
+func TestUpdateRollback(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	rolledBack := mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	backup := mockBackup(apiMock)
+	apiMock.On("GetPluginStatus", "topdf").Once().Return(&model.PluginStatus{State: model.PluginStateRunning}, nil)
+	apiMock.On("GetPluginStatus", "topdf").Once().Return(&model.PluginStatus{State: model.PluginStateFailedToStart}, nil)
+	apiMock.On("GetBundlePath").Return("testdata/plugins/marketplace-addon", nil)
+	history := mockHistory(apiMock)
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		data, err := ioutil.ReadAll(args.Get(0).(io.Reader))
+		require.NoError(t, err)
+		require.Equal(t, readTestdata(t, "topdf-1.3.0.tar.gz"), data)
+	})
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		gr, err := gzip.NewReader(args.Get(0).(io.Reader))
+		require.NoError(t, err)
+		header, err := tar.NewReader(gr).Next()
+		require.NoError(t, err)
+		require.Equal(t, "topdf/", header.Name)
+	})
+
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.4.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}}},
+	}, nil)
+
+	notifications := make(chan Notification, 1)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), NotificationsOption(notifications))
+	updateOp, err := NewUpdateOp(
+		&model.Manifest{Id: "topdf", Version: "1.2.1"},
+		&model.BaseMarketplacePlugin{
+			DownloadURL: buildDownloadURL(ts.URL, "topdf-1.3.0.tar.gz"),
+			Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+		}, nil, "5.4.0")
+	require.NoError(t, err)
+	updater.update(updateOp)
+
+	update := <-notifications
+	require.Equal(t, "topdf", update.PluginID)
+	require.Nil(t, update.Updated)
+	rollbackErr, ok := update.Error.(*RollbackError)
+	require.True(t, ok)
+	require.Equal(t, "topdf", rollbackErr.PluginID)
+	require.Equal(t, "1.3.0", rollbackErr.FailedPluginVersion)
+	require.Equal(t, "1.2.1", rollbackErr.RestoredPluginVersion)
+	require.Equal(t, "plugin failed to start", rollbackErr.Err.Error())
+
+	require.Len(t, *history, 1)
+	require.Equal(t, OutcomeRolledBack, (*history)[0].Outcome)
+	require.Equal(t, rollbackErr.Error(), (*history)[0].Error)
+
+	// the rolled back version is not installed again by the update checks.
+	require.Equal(t, map[string]string{"topdf": "1.3.0"}, *rolledBack)
+	statuses, err := updater.Status(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &RolledBackVersionError{PluginID: "topdf", NextPluginVersion: "1.3.0"}, statuses[0].Err)
+	require.Empty(t, backup.values)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestUpdateRollbackAfterTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	rolledBack := mockRolledBack(apiMock)
+	mockBackup(apiMock)
+	apiMock.On("GetPluginStatus", "topdf").Once().Return(&model.PluginStatus{State: model.PluginStateRunning}, nil)
+	apiMock.On("GetPluginStatus", "topdf").Once().Return(&model.PluginStatus{State: model.PluginStateFailedToStart}, nil)
+	apiMock.On("GetBundlePath").Return("testdata/plugins/marketplace-addon", nil)
+	history := mockHistory(apiMock)
+	// the new version is installed after the deadline, then it fails the health checks.
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil).Run(func(mock.Arguments) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+
+	notifications := make(chan Notification, 1)
+	updater := New(apiMock, nil, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		UpdateTimeoutOption(50 * time.Millisecond),
+	}...)
+	events := updater.Subscribe(10)
+	updateOp, err := NewUpdateOp(
+		&model.Manifest{Id: "topdf", Version: "1.2.1"},
+		&model.BaseMarketplacePlugin{
+			DownloadURL: buildDownloadURL(ts.URL, "topdf-1.3.0.tar.gz"),
+			Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+		}, nil, "5.4.0")
+	require.NoError(t, err)
+	updater.update(updateOp)
+	events.Cancel()
+
+	update := <-notifications
+	require.IsType(t, &RollbackError{}, update.Error)
+	require.Equal(t, map[string]string{"topdf": "1.3.0"}, *rolledBack)
+	require.Len(t, *history, 1)
+	require.Equal(t, OutcomeRolledBack, (*history)[0].Outcome)
+	var types []EventType
+	for event := range events.C {
+		types = append(types, event.Type)
+	}
+	require.Contains(t, types, EventRolledBack)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestBackupChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "topdf"), 0755))
+	// random data is not compressed, so the bundle is split into chunks.
+	data := make([]byte, 2*backupChunkSize+1)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "topdf", "data"), data, 0644))
+	bundle, err := xplugin.BundleFromDir(filepath.Join(dir, "topdf"))
+	require.NoError(t, err)
+
+	apiMock := &apimock.API{}
+	backup := mockBackup(apiMock)
+	apiMock.On("GetBundlePath").Return(filepath.Join(dir, "marketplace-addon"), nil)
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil).Run(func(args mock.Arguments) {
+		restored, err := ioutil.ReadAll(args.Get(0).(io.Reader))
+		require.NoError(t, err)
+		require.Equal(t, bundle, restored)
+	})
+
+	updater := New(apiMock, nil, dlocktest.NewStore())
+	require.NoError(t, updater.backup(context.Background(), "topdf"))
+	require.Len(t, backup.values, 4)
+	for i := 0; i < 3; i++ {
+		require.True(t, len(backup.get(backupChunkKey("topdf", i))) <= backupChunkSize)
+	}
+	require.NoError(t, updater.restore("topdf"))
+
+	// an incomplete backup cannot be restored.
+	backup.set(backupChunkKey("topdf", 2), nil)
+	require.EqualError(t, updater.restore("topdf"), "the backup bundle is incomplete")
+
+	updater.deleteBackup("topdf")
+	require.Empty(t, backup.values)
+
+	// a cancelled backup is deleted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = updater.backup(ctx, "topdf")
+	require.Equal(t, context.Canceled, errors.Cause(err))
+	require.Empty(t, backup.values)
+	apiMock.AssertExpectations(t)
+}
+
+func TestBackupFailed(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateRunning}, nil)
+	apiMock.On("GetBundlePath").Return("", errors.New("no bundle path"))
+	apiMock.On("LogError", mock.Anything)
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+
+	notifications := make(chan Notification, 2)
+	updater := New(apiMock, nil, dlocktest.NewStore(), NotificationsOption(notifications))
+	updateOp, err := NewUpdateOp(
+		&model.Manifest{Id: "topdf", Version: "1.2.1"},
+		&model.BaseMarketplacePlugin{
+			DownloadURL: buildDownloadURL(ts.URL, "topdf-1.3.0.tar.gz"),
+			Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+		}, nil, "5.4.0")
+	require.NoError(t, err)
+
+	// the update is made without a backup and admins are notified about it once.
+	require.NoError(t, updater.install(context.Background(), updateOp))
+	require.Len(t, notifications, 1)
+	notification := <-notifications
+	backupErr, ok := notification.Error.(*BackupError)
+	require.True(t, ok)
+	require.Equal(t, "topdf", backupErr.PluginID)
+	require.Equal(t, "1.3.0", backupErr.NextPluginVersion)
+	require.EqualError(t, errors.Cause(backupErr.Err), "no bundle path")
+
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+	require.NoError(t, updater.install(context.Background(), updateOp))
+	require.Empty(t, notifications)
+	apiMock.AssertExpectations(t)
+}
+
+func TestStatus(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "github", Version: "2.3.0"},
+		{Id: "jira", Version: "2.3.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", `found 4 installed plugins`)
+	apiMock.On("LogInfo", `found 3 plugins in the marketplace`)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "3.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), UpdatePolicyOption(PolicyMinor, nil))
+	require.NoError(t, updater.SkipPlugin("topdf"))
+	statuses, err := updater.Status(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []PluginStatus{
+		{PluginID: "topdf", InstalledVersion: "1.2.1", LatestVersion: "1.3.0", Err: &HoldError{
+			PluginID:          "topdf",
+			NextPluginVersion: "1.3.0",
+			Hold:              Hold{PluginID: "topdf", Reason: "skipped"},
+		}},
+		{PluginID: "github", InstalledVersion: "2.3.0", Err: &marketplace.NotFoundError{ID: "github"}},
+		{PluginID: "jira", InstalledVersion: "2.3.0", LatestVersion: "3.0.0", Err: &UpdatePolicyError{
+			PluginID:             "jira",
+			CurrentPluginVersion: "2.3.0",
+			NextPluginVersion:    "3.0.0",
+			Policy:               PolicyMinor,
+			RequiredPolicy:       PolicyMajor,
+		}},
+		{PluginID: "zoom", InstalledVersion: "1.0.0", LatestVersion: "1.0.0", Err: ErrNoNewerVersion},
+	}, statuses)
+
+	require.Equal(t, ErrPluginNotInstalled, updater.UpdatePlugin("antivirus"))
+	require.Equal(t, ErrNoNewerVersion, updater.UpdatePlugin("zoom"))
+
+	apiMock.AssertExpectations(t)
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestStatusAllowedVersion(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "jira", Version: "2.3.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "2.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.2.3"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "2.4.0", MinServerVersion: "5.20.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "2.3.5"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.1.0", MinServerVersion: "5.20.0"}}},
+	}, nil)
+
+	// newer versions that cannot be installed do not hide the ones that can be.
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), UpdatePolicyOption(PolicyMajor, map[string]UpdatePolicy{
+		"topdf": PolicyPatch,
+	}))
+	statuses, err := updater.Status(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []PluginStatus{
+		{PluginID: "topdf", InstalledVersion: "1.2.1", LatestVersion: "1.2.3"},
+		{PluginID: "jira", InstalledVersion: "2.3.0", LatestVersion: "2.3.5"},
+		{PluginID: "zoom", InstalledVersion: "1.0.0", LatestVersion: "1.1.0", Err: &ServerVersionError{
+			PluginID:              "zoom",
+			CurrentPluginVersion:  "1.0.0",
+			NextPluginVersion:     "1.1.0",
+			CurrentServerVersion:  "5.18.0",
+			RequiredServerVersion: "5.20.0",
+		}},
+	}, statuses)
+
+	apiMock.AssertExpectations(t)
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestStatusCancelled(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Once().Return(nil, &marketplace.APIError{StatusCode: http.StatusServiceUnavailable})
+
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), RetryOption(RetryPolicy{
+		Attempts:  3,
+		BaseDelay: time.Hour,
+	}))
+	// listing is not retried once the caller is gone.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := updater.Status(ctx)
+	require.IsType(t, &marketplace.APIError{}, errors.Cause(err))
+
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestStatusSoak(t *testing.T) {
+	now := time.Now().UTC()
+	published := now.Add(-24 * time.Hour)
+	seen := now.Add(-10 * 24 * time.Hour).Truncate(time.Second)
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "jira", Version: "2.3.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+	// jira 3.0.0 is seen before, zoom 1.1.0 is seen for the first time and replaces 1.0.5.
+	seenData := func(version string, at time.Time) []byte {
+		data, err := json.Marshal(firstSeen{Version: version, Time: at})
+		require.NoError(t, err)
+		return data
+	}
+	seenValues := map[string][]byte{
+		firstSeenKeyPrefix + "jira": seenData("3.0.0", seen),
+		firstSeenKeyPrefix + "zoom": seenData("1.0.5", seen),
+	}
+	isSeenKey := mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, firstSeenKeyPrefix) })
+	apiMock.On("KVGet", isSeenKey).Return(func(key string) []byte { return seenValues[key] }, nil)
+	apiMock.On("KVCompareAndSet", isSeenKey, mock.Anything, mock.Anything).Return(
+		func(key string, oldData, newData []byte) bool {
+			if !bytes.Equal(oldData, seenValues[key]) {
+				return false
+			}
+			seenValues[key] = newData
+			return true
+		}, nil)
+	apiMock.On("KVCompareAndDelete", isSeenKey, mock.Anything).Return(
+		func(key string, oldData []byte) bool {
+			if !bytes.Equal(oldData, seenValues[key]) {
+				return false
+			}
+			delete(seenValues, key)
+			return true
+		}, nil)
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{
+			BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}},
+			UpdatedAt:             published,
+		},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "3.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.1.0"}}},
+	}, nil)
+
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		MinReleaseAgeOption(7 * 24 * time.Hour),
+		UpdatePolicyOption(PolicyMajor, nil),
+	}...)
+	statuses, err := updater.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.Equal(t, &SoakError{
+		PluginID:          "topdf",
+		NextPluginVersion: "1.3.0",
+		ReleasedAt:        published,
+		Until:             published.Add(7 * 24 * time.Hour),
+	}, statuses[0].Err)
+	require.NoError(t, statuses[1].Err)
+	require.IsType(t, &SoakError{}, statuses[2].Err)
+	require.WithinDuration(t, now.Add(7*24*time.Hour), statuses[2].Err.(*SoakError).Until, time.Minute)
+	require.Len(t, seenValues, 2)
+
+	// the first seen time is kept until the version is installed.
+	updater.deleteFirstSeen("zoom", "1.0.5")
+	require.Contains(t, seenValues, firstSeenKeyPrefix+"zoom")
+	updater.deleteFirstSeen("zoom", "1.1.0")
+	require.NotContains(t, seenValues, firstSeenKeyPrefix+"zoom")
+	updater.deleteFirstSeen("topdf", "1.3.0")
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestDryRun(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{
+		{Id: "topdf", Version: "1.2.1"},
+		{Id: "jira", Version: "2.3.0"},
+		{Id: "zoom", Version: "1.0.0"},
+	}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Version: "1.3.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "jira", Version: "3.0.0"}}},
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "zoom", Version: "1.0.0"}}},
+	}, nil)
+
+	notifications := make(chan Notification, 3)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		UpdatePolicyOption(PolicyMinor, nil),
+		DryRunOption(true),
+	}...)
+	updater.checkAndUpdate()
+	// planned updates are only notified once.
+	updater.checkAndUpdate()
+	close(notifications)
+
+	var planned []Notification
+	for notification := range notifications {
+		planned = append(planned, notification)
+	}
+	require.Len(t, planned, 2)
+	require.Equal(t, "topdf", planned[0].PluginID)
+	require.Equal(t, &Changelog{PreviousVersion: "1.2.1", UpdatedVersion: "1.3.0"}, planned[0].Planned)
+	require.NoError(t, planned[0].Error)
+	require.Equal(t, "jira", planned[1].PluginID)
+	require.Equal(t, &Changelog{PreviousVersion: "2.3.0", UpdatedVersion: "3.0.0"}, planned[1].Planned)
+	require.IsType(t, &UpdatePolicyError{}, planned[1].Error)
+
+	require.Equal(t, ErrDryRun, updater.UpdatePlugin("topdf"))
+	// planned updates are still announced once dry-run mode is turned off.
+	require.True(t, updater.announce("jira", "3.0.0"))
+
+	apiMock.AssertNotCalled(t, "InstallPlugin", mock.Anything, mock.Anything)
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(marketplace.Plugins{
+		{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: "topdf", Name: "TOPDF", Version: "1.3.0"}}},
+	}, nil)
+
+	// open the window in a day that is not today.
+	day := time.Now().UTC().Add(time.Hour * 24 * 3).Weekday().String()[:3]
+	window, err := xtime.ParseWindow(day + " 02:00-05:00")
+	require.NoError(t, err)
+
+	notifications := make(chan Notification, 2)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		MaintenanceWindowsOption([]xtime.Window{window}),
+	}...)
+	updater.checkAndUpdate()
+	// queued updates are only notified once.
+	updater.checkAndUpdate()
+	close(notifications)
+
+	var queued []Notification
+	for notification := range notifications {
+		queued = append(queued, notification)
+	}
+	require.Len(t, queued, 1)
+	require.Equal(t, "topdf", queued[0].PluginID)
+	require.Equal(t, "1.3.0", queued[0].Queued.UpdatedVersion)
+	require.Equal(t, window.Next(time.Now()), queued[0].QueuedUntil)
+
+	apiMock.AssertNotCalled(t, "InstallPlugin", mock.Anything, mock.Anything)
+}
+
+func TestMarketplaceError(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("LogError", mock.Anything).Twice()
+	apiMock.On("LogWarn", mock.Anything).Once()
+
+	rejected := &marketplace.APIError{StatusCode: http.StatusUnauthorized, Endpoint: "/api/v1/plugins"}
+	unavailable := &marketplace.APIError{StatusCode: http.StatusBadGateway, Endpoint: "/api/v1/plugins"}
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Twice().Return(nil, rejected)
+	marketplaceMock.On("ListPlugins").Once().Return(nil, unavailable)
+
+	notifications := make(chan Notification, 3)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		RetryOption(RetryPolicy{Attempts: 1}),
+	}...)
+	// rejected requests are notified once and unavailable Marketplaces are only logged.
+	updater.checkAndUpdate()
+	updater.checkAndUpdate()
+	updater.checkAndUpdate()
+	close(notifications)
+
+	var errs []error
+	for notification := range notifications {
+		errs = append(errs, notification.Error)
+	}
+	require.Len(t, errs, 1)
+	require.Equal(t, rejected, errors.Cause(errs[0]))
+
+	apiMock.AssertExpectations(t)
+	marketplaceMock.AssertExpectations(t)
+}
+
+func TestUpdateTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateNotRunning}, nil)
+	history := mockHistory(apiMock)
+
+	notifications := make(chan Notification, 1)
+	updater := New(apiMock, nil, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		UpdateTimeoutOption(time.Millisecond * 50),
+	}...)
+	updateOp, err := NewUpdateOp(
+		&model.Manifest{Id: "topdf", Version: "1.2.1"},
+		&model.BaseMarketplacePlugin{
+			DownloadURL: ts.URL,
+			Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+		}, nil, "5.4.0")
+	require.NoError(t, err)
+	updater.update(updateOp)
+
+	update := <-notifications
+	timeoutErr, ok := update.Error.(*TimeoutError)
+	require.True(t, ok)
+	require.Equal(t, "topdf", timeoutErr.PluginID)
+	require.Equal(t, "1.3.0", timeoutErr.NextPluginVersion)
+	require.Equal(t, time.Millisecond*50, timeoutErr.Timeout)
+	require.Equal(t, errors.Cause(timeoutErr.Err), errors.Cause(timeoutErr))
+	require.Len(t, *history, 1)
+	require.Equal(t, OutcomeFailed, (*history)[0].Outcome)
+
+	apiMock.AssertNotCalled(t, "InstallPlugin", mock.Anything, mock.Anything)
+}
+
+func TestUpdateRetry(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/flaky":
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(buildBundle(t, "topdf", "1.3.0"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	apiMock.On("GetPluginStatus", "topdf").Return(&model.PluginStatus{State: model.PluginStateNotRunning}, nil)
+	apiMock.On("LogWarn", mock.Anything).Twice()
+	apiMock.On("InstallPlugin", mock.Anything, true).Once().Return(nil, nil)
+	mockHistory(apiMock)
+
+	notifications := make(chan Notification, 1)
+	updater := New(apiMock, nil, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		RetryOption(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	}...)
+	newUpdateOp := func(downloadURL string) *UpdateOp {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: "topdf", Version: "1.2.1"},
+			&model.BaseMarketplacePlugin{
+				DownloadURL: downloadURL,
+				Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+			}, nil, "5.4.0")
+		require.NoError(t, err)
+		return updateOp
+	}
+
+	// server errors are retried.
+	updater.update(newUpdateOp(ts.URL + "/flaky"))
+	update := <-notifications
+	require.NoError(t, update.Error)
+	require.NotNil(t, update.Updated)
+	require.Equal(t, 3, requests)
+
+	// client errors are not retried.
+	requests = 0
+	updater.update(newUpdateOp(ts.URL + "/missing"))
+	update = <-notifications
+	require.IsType(t, &xplugin.StatusError{}, errors.Cause(update.Error))
+	require.Equal(t, 1, requests)
+
+	// the same failure is only notified once.
+	updater.update(newUpdateOp(ts.URL + "/missing"))
+	require.Len(t, notifications, 0)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestReportErrorsOnce(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return([]*model.Manifest{{Id: "topdf", Version: "1.2.1"}}, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.4.0")
+
+	latest := &model.Manifest{Id: "topdf", Version: "1.3.0", MinServerVersion: "5.6.0"}
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(func() marketplace.Plugins {
+		return marketplace.Plugins{{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{Manifest: latest}}}
+	}, nil)
+
+	notifications := make(chan Notification, 3)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), NotificationsOption(notifications))
+	require.Empty(t, updater.discover(&round{}))
+	require.Empty(t, updater.discover(&round{}))
+	require.Len(t, notifications, 1)
+	require.IsType(t, &ServerVersionError{}, (<-notifications).Error)
+
+	// a new version is notified again.
+	latest = &model.Manifest{Id: "topdf", Version: "1.4.0", MinServerVersion: "5.6.0"}
+	require.Empty(t, updater.discover(&round{}))
+	require.Len(t, notifications, 1)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestUpdateConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 20)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write(buildBundle(t, path.Base(r.URL.Path), "1.0.1"))
+	}))
+	defer ts.Close()
+
+	var installed []*model.Manifest
+	var plugins marketplace.Plugins
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("plugin%d", i)
+		installed = append(installed, &model.Manifest{Id: id, Version: "1.0.0"})
+		plugins = append(plugins, &marketplace.Plugin{BaseMarketplacePlugin: &model.BaseMarketplacePlugin{
+			DownloadURL: buildDownloadURL(ts.URL, id),
+			Manifest:    &model.Manifest{Id: id, Version: "1.0.1"},
+		}})
+	}
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHolds(apiMock)
+	apiMock.On("GetPlugins").Return(installed, nil)
+	apiMock.On("LogInfo", mock.Anything)
+	apiMock.On("GetServerVersion").Return("5.18.0")
+	apiMock.On("GetPluginStatus", mock.Anything).Return(&model.PluginStatus{State: model.PluginStateNotRunning}, nil)
+	apiMock.On("InstallPlugin", mock.Anything, true).Times(6).Return(nil, nil)
+	mockHistory(apiMock)
+
+	marketplaceMock := &updatermock.Marketplace{}
+	marketplaceMock.On("ListPlugins").Return(plugins, nil)
+
+	notifications := make(chan Notification, 6)
+	updater := New(apiMock, marketplaceMock, dlocktest.NewStore(), []Option{
+		NotificationsOption(notifications),
+		ConcurrencyOption(2),
+	}...)
+	updater.checkAndUpdate()
+	require.Len(t, notifications, 6)
+	require.Equal(t, 2, maxInFlight)
+
+	apiMock.AssertExpectations(t)
+}
+
+func TestHistory(t *testing.T) {
+	apiMock := &apimock.API{}
+	mockRolledBack(apiMock)
+	mockHistory(apiMock)
+	updater := New(apiMock, nil, dlocktest.NewStore())
+	for i, id := range []string{"topdf", "github", "topdf", "jira", "topdf"} {
+		updateOp, err := NewUpdateOp(
+			&model.Manifest{Id: id, Version: fmt.Sprintf("1.%d.0", i)},
+			&model.BaseMarketplacePlugin{Manifest: &model.Manifest{Id: id, Version: fmt.Sprintf("1.%d.0", i+1)}},
+			nil, "5.18.0")
+		require.NoError(t, err)
+		var updateErr error
+		if id == "github" {
+			updateErr = errors.New("could not install the plugin")
+		}
+		updater.record(updateOp, updateErr)
+	}
+
+	records, err := updater.History(HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	require.Equal(t, "topdf", records[0].PluginID)
+	require.Equal(t, "1.4.0", records[0].FromVersion)
+	require.Equal(t, "1.5.0", records[0].ToVersion)
+	require.Equal(t, "github", records[3].PluginID)
+	require.Equal(t, OutcomeFailed, records[3].Outcome)
+	require.Equal(t, "could not install the plugin", records[3].Error)
+
+	records, err = updater.History(HistoryQuery{PluginID: "topdf", Page: 1, PerPage: 2})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "1.0.0", records[0].FromVersion)
+
+	records, err = updater.History(HistoryQuery{PluginID: "topdf", Page: 2, PerPage: 2})
+	require.NoError(t, err)
+	require.Len(t, records, 0)
+}
+
+// mockHistory mocks the KV store calls made for the history and returns the saved records.
+func mockHistory(apiMock *apimock.API) *[]HistoryRecord {
+	var data []byte
+	var records []HistoryRecord
+	apiMock.On("KVGet", historyKey).Return(func(string) []byte { return data }, nil)
+	apiMock.On("KVCompareAndSet", historyKey, mock.Anything, mock.Anything).Return(true, nil).Run(func(args mock.Arguments) {
+		data = args.Get(2).([]byte)
+		json.Unmarshal(data, &records)
+	})
+	return &records
+}
+
+// mockHolds mocks the holds in the KV store and returns them.
+func mockHolds(apiMock *apimock.API) *[]Hold {
+	var data []byte
+	var holds []Hold
+	apiMock.On("KVGet", holdsKey).Return(func(string) []byte { return data }, nil)
+	apiMock.On("KVCompareAndSet", holdsKey, mock.Anything, mock.Anything).Maybe().Return(true, nil).Run(func(args mock.Arguments) {
+		data = args.Get(2).([]byte)
+		holds = nil
+		json.Unmarshal(data, &holds)
+	})
+	return &holds
+}
+
+// mockBackup mocks the backup keys in the KV store and returns them.
+func mockBackup(apiMock *apimock.API) *backupStore {
+	store := &backupStore{values: make(map[string][]byte)}
+	isBackupKey := mock.MatchedBy(func(key string) bool { return strings.HasPrefix(key, backupKeyPrefix) })
+	apiMock.On("KVGet", isBackupKey).Return(store.get, nil)
+	apiMock.On("KVSet", isBackupKey, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		store.set(args.String(0), args.Get(1).([]byte))
+	})
+	apiMock.On("KVDelete", isBackupKey).Return(nil).Run(func(args mock.Arguments) {
+		store.set(args.String(0), nil)
+	})
+	return store
+}
+
+// backupStore keeps the backup keys of a mocked KV store.
+type backupStore struct {
+	m      sync.Mutex
+	values map[string][]byte
+	// saved is the number of saved values.
+	saved int
+}
+
+func (s *backupStore) get(key string) []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.values[key]
+}
+
+// set sets the value of key, a nil value deletes it.
+func (s *backupStore) set(key string, value []byte) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if value == nil {
+		delete(s.values, key)
+		return
+	}
+	s.values[key] = value
+	s.saved++
+}
+
+func mockRolledBack(apiMock *apimock.API) *map[string]string {
+	var data []byte
+	var versions map[string]string
+	apiMock.On("KVGet", rolledBackKey).Maybe().Return(func(string) []byte { return data }, nil)
+	apiMock.On("KVCompareAndSet", rolledBackKey, mock.Anything, mock.Anything).Maybe().Return(true, nil).Run(func(args mock.Arguments) {
+		data = args.Get(2).([]byte)
+		versions = nil
+		json.Unmarshal(data, &versions)
+	})
+	return &versions
+}
+
 func buildDownloadURL(baseURL, file string) string {
 	u, _ := url.Parse(baseURL)
 	u.Path = path.Join(u.Path, file)
 	return u.String()
 }
+
+// readTestdata reads file from the testdata dir.
+func readTestdata(t *testing.T, file string) []byte {
+	data, err := ioutil.ReadFile(path.Join("testdata", file))
+	require.NoError(t, err)
+	return data
+}
+
+// buildBundle creates a plugin bundle for version of plugin with id.
+func buildBundle(t *testing.T, id, version string) []byte {
+	manifest, err := json.Marshal(&model.Manifest{Id: id, Version: version})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     id + "/plugin.json",
+		Mode:     0644,
+		Size:     int64(len(manifest)),
+		Typeflag: tar.TypeReg,
+	}))
+	_, err = tw.Write(manifest)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
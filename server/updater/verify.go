@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	// signatureExtension is appended to a bundle's download URL to get its detached GPG signature.
+	signatureExtension = ".sig"
+
+	// digestExtension is appended to a bundle's download URL to get its SHA-256 digest.
+	digestExtension = ".sha256"
+)
+
+// VerificationMode defines how downloaded bundles are verified before they're installed.
+type VerificationMode string
+
+const (
+	// VerificationOff disables verifying bundles.
+	VerificationOff VerificationMode = "off"
+
+	// VerificationLenient rejects bundles that do not match with their published signatures or
+	// digests but installs bundles that have none of them.
+	VerificationLenient VerificationMode = "lenient"
+
+	// VerificationStrict only installs bundles that are verified with a published signature or
+	// a digest. a valid signature is required when there are public keys to check it with, the
+	// digest is then only used as an integrity check.
+	VerificationStrict VerificationMode = "strict"
+)
+
+// ParseVerificationMode parses a verification mode from s. an empty s is parsed as VerificationOff.
+func ParseVerificationMode(s string) (VerificationMode, error) {
+	mode := VerificationMode(s)
+	switch mode {
+	case "":
+		return VerificationOff, nil
+	case VerificationOff, VerificationLenient, VerificationStrict:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown verification mode %q", s)
+}
+
+// ParsePublicKeys parses ASCII armored GPG public keys that are used to verify bundle signatures.
+func ParsePublicKeys(armored string) (openpgp.EntityList, error) {
+	if strings.TrimSpace(armored) == "" {
+		return nil, nil
+	}
+	keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read public keys")
+	}
+	return keys, nil
+}
+
+// verify verifies bundle downloaded with updateOp against its detached signature and SHA-256
+// digest published next to it in the Marketplace.
+func (u *Updater) verify(ctx context.Context, updateOp *UpdateOp, bundle *xplugin.Bundle) error {
+	conf := u.cloneConfing()
+	if conf.verificationMode == "" || conf.verificationMode == VerificationOff {
+		return nil
+	}
+	newError := func(reason string) error {
+		return &VerificationError{
+			PluginID:          updateOp.installed.Id,
+			NextPluginVersion: updateOp.next.Manifest.Version,
+			Reason:            reason,
+		}
+	}
+	signatureURL, err := publishedURL(updateOp.next.DownloadURL, signatureExtension)
+	if err != nil {
+		return err
+	}
+	digestURL, err := publishedURL(updateOp.next.DownloadURL, digestExtension)
+	if err != nil {
+		return err
+	}
+	verified := false
+	// verify the signature if there are keys to check it with.
+	if len(conf.publicKeys) > 0 {
+		signature, err := u.downloadPublished(ctx, conf, signatureURL)
+		if err != nil {
+			return err
+		}
+		// a digest is published by the same mirror, so it cannot replace a missing signature.
+		if signature == nil && conf.verificationMode == VerificationStrict {
+			return newError("no signature is published")
+		}
+		if signature != nil {
+			r, err := bundle.Reader()
+			if err != nil {
+				return err
+			}
+			if err := checkSignature(conf.publicKeys, r, signature); err != nil {
+				return newError(fmt.Sprintf("invalid signature: %s", err))
+			}
+			verified = true
+		}
+	}
+	// verify the digest.
+	digest, err := u.downloadPublished(ctx, conf, digestURL)
+	if err != nil {
+		return err
+	}
+	if digest != nil {
+		r, err := bundle.Reader()
+		if err != nil {
+			return err
+		}
+		if err := checkDigest(r, digest); err != nil {
+			return newError(err.Error())
+		}
+		verified = true
+	}
+	if !verified && conf.verificationMode == VerificationStrict {
+		return newError("no signature or digest is published")
+	}
+	return nil
+}
+
+// publishedURL returns the URL of a file with extension published next to the bundle at
+// downloadURL. the extension is appended to the path so query strings are kept as is.
+func publishedURL(downloadURL, extension string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid download URL %q", downloadURL)
+	}
+	u.Path += extension
+	if u.RawPath != "" {
+		u.RawPath += extension
+	}
+	return u.String(), nil
+}
+
+// downloadPublished downloads a file published next to a bundle from url.
+// it returns with nil when the file is not published.
+func (u *Updater) downloadPublished(ctx context.Context, conf config, url string) ([]byte, error) {
+	var data []byte
+	err := u.retry(ctx, fmt.Sprintf("downloading %q", url), func() (err error) {
+		data, err = u.downloader(conf).Fetch(ctx, url)
+		return err
+	})
+	if e, ok := errors.Cause(err).(*xplugin.StatusError); ok && e.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot download %q", url)
+	}
+	return data, nil
+}
+
+// checkSignature checks bundle against a binary or ASCII armored detached signature signed
+// with one of keys.
+func checkSignature(keys openpgp.EntityList, bundle io.Reader, signature []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keys, bundle, bytes.NewReader(signature))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keys, bundle, bytes.NewReader(signature))
+	}
+	return err
+}
+
+// checkDigest checks bundle against a hex encoded SHA-256 digest in the format of sha256sum's
+// output.
+func checkDigest(bundle io.Reader, digest []byte) error {
+	fields := strings.Fields(string(digest))
+	if len(fields) == 0 {
+		return errors.New("empty digest")
+	}
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil || len(expected) != sha256.Size {
+		return fmt.Errorf("invalid digest %q", fields[0])
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, bundle); err != nil {
+		return errors.Wrap(err, "cannot read the bundle")
+	}
+	actual := h.Sum(nil)
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("digest mismatch, expected %x but got %x", expected, actual)
+	}
+	return nil
+}
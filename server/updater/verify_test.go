@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ilgooz/mattermost-dlock/dlocktest"
+	"github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin"
+	apimock "github.com/ilgooz/mattermost-plugin-marketplace-addon/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestVerify(t *testing.T) {
+	bundle := buildBundle(t, "topdf", "1.3.0")
+	digest := []byte(fmt.Sprintf("%x  topdf-1.3.0.tar.gz\n", sha256.Sum256(bundle)))
+
+	publisher, err := openpgp.NewEntity("publisher", "", "publisher@example.com", nil)
+	require.NoError(t, err)
+	stranger, err := openpgp.NewEntity("stranger", "", "stranger@example.com", nil)
+	require.NoError(t, err)
+	sign := func(signer *openpgp.Entity, armored bool) []byte {
+		var buf bytes.Buffer
+		if armored {
+			require.NoError(t, openpgp.ArmoredDetachSign(&buf, signer, bytes.NewReader(bundle), nil))
+		} else {
+			require.NoError(t, openpgp.DetachSign(&buf, signer, bytes.NewReader(bundle), nil))
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name      string
+		mode      VerificationMode
+		keys      openpgp.EntityList
+		published map[string][]byte
+		rejected  bool
+	}{
+		{"off", VerificationOff, nil, map[string][]byte{"/topdf.sha256": []byte("bad")}, false},
+		{"lenient without files", VerificationLenient, nil, nil, false},
+		{"strict without files", VerificationStrict, nil, nil, true},
+		{"valid digest", VerificationStrict, nil, map[string][]byte{"/topdf.sha256": digest}, false},
+		{"invalid digest", VerificationLenient, nil, map[string][]byte{"/topdf.sha256": []byte("abc")}, true},
+		{"mismatched digest", VerificationLenient, nil, map[string][]byte{
+			"/topdf.sha256": []byte(fmt.Sprintf("%x", sha256.Sum256([]byte("tampered")))),
+		}, true},
+		{"valid signature", VerificationStrict, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sig": sign(publisher, false),
+		}, false},
+		{"valid armored signature", VerificationStrict, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sig": sign(publisher, true),
+		}, false},
+		{"untrusted signature", VerificationLenient, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sig":    sign(stranger, false),
+			"/topdf.sha256": digest,
+		}, true},
+		{"signature without keys", VerificationStrict, nil, map[string][]byte{
+			"/topdf.sig": sign(publisher, false),
+		}, true},
+		{"strict digest without signature", VerificationStrict, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sha256": digest,
+		}, true},
+		{"lenient digest without signature", VerificationLenient, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sha256": digest,
+		}, false},
+		{"valid signature with digest", VerificationStrict, openpgp.EntityList{publisher}, map[string][]byte{
+			"/topdf.sig":    sign(publisher, false),
+			"/topdf.sha256": digest,
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/topdf" {
+					w.Write(bundle)
+					return
+				}
+				data, ok := tt.published[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(data)
+			}))
+			defer ts.Close()
+
+			updater := New(&apimock.API{}, nil, dlocktest.NewStore(), VerificationOption(tt.mode, tt.keys))
+			updateOp := &UpdateOp{
+				installed: &model.Manifest{Id: "topdf", Version: "1.2.1"},
+				next: &model.BaseMarketplacePlugin{
+					DownloadURL: ts.URL + "/topdf?token=secret",
+					Manifest:    &model.Manifest{Id: "topdf", Version: "1.3.0"},
+				},
+			}
+
+			downloaded, err := xplugin.NewDownloader().Download(context.Background(), updateOp.next.DownloadURL, "topdf")
+			require.NoError(t, err)
+			defer downloaded.Close()
+
+			err = updater.verify(context.Background(), updateOp, downloaded)
+			if !tt.rejected {
+				require.NoError(t, err)
+				return
+			}
+			require.IsType(t, &VerificationError{}, err)
+			require.Equal(t, "topdf", err.(*VerificationError).PluginID)
+			require.Equal(t, "1.3.0", err.(*VerificationError).NextPluginVersion)
+		})
+	}
+}
+
+func TestPublishedURL(t *testing.T) {
+	signatureURL, err := publishedURL("https://example.com/topdf-1.3.0.tar.gz?token=secret", signatureExtension)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/topdf-1.3.0.tar.gz.sig?token=secret", signatureURL)
+
+	_, err = publishedURL("://example.com", digestExtension)
+	require.Error(t, err)
+}
+
+func TestParseVerificationMode(t *testing.T) {
+	mode, err := ParseVerificationMode("")
+	require.NoError(t, err)
+	require.Equal(t, VerificationOff, mode)
+
+	mode, err = ParseVerificationMode("strict")
+	require.NoError(t, err)
+	require.Equal(t, VerificationStrict, mode)
+
+	_, err = ParseVerificationMode("paranoid")
+	require.Error(t, err)
+}
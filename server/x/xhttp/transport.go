@@ -0,0 +1,202 @@
+package xhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultConnectTimeout is the default max time to wait for a connection to be established.
+	defaultConnectTimeout = 30 * time.Second
+)
+
+// TransportConfig configures the HTTP transport used to reach Marketplaces and to download
+// plugin bundles.
+type TransportConfig struct {
+	// ProxyURL is the proxy that requests are sent through. requests use the proxy set by the
+	// environment variables when it's empty.
+	ProxyURL string
+
+	// CACertificates are PEM encoded certificates of the CAs that are trusted in addition to the
+	// system's CAs.
+	CACertificates string
+
+	// ClientCertificate and ClientKey are the PEM encoded certificate and private key sent to the
+	// servers that ask for a client certificate.
+	ClientCertificate, ClientKey string
+
+	// Headers are sent with requests.
+	Headers []Header
+
+	// HeaderHosts are the hosts that the headers without a host are sent to, e.g. the hosts of
+	// the Marketplaces. headers without a host are not sent to other hosts, including the ones
+	// that requests are redirected to.
+	HeaderHosts []string
+
+	// ConnectTimeout is the max time to wait for a connection and TLS handshake.
+	ConnectTimeout time.Duration
+}
+
+// Header is an HTTP header that is sent with requests.
+type Header struct {
+	// Host that the header is only sent to. the header is sent to the HeaderHosts of
+	// TransportConfig when it's empty.
+	Host string
+
+	// Name and Value of the header.
+	Name, Value string
+}
+
+// NewTransport creates a new HTTP transport with conf.
+func NewTransport(conf TransportConfig) (http.RoundTripper, error) {
+	connectTimeout := conf.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	proxy := http.ProxyFromEnvironment
+	if conf.ProxyURL != "" {
+		proxyURL, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid proxy URL %q", conf.ProxyURL)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	// the settings other than the configured ones are the same with http.DefaultTransport's.
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   connectTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if len(conf.Headers) > 0 {
+		transport = &headerTransport{headers: conf.Headers, hosts: conf.HeaderHosts, next: transport}
+	}
+	return transport, nil
+}
+
+// newTLSConfig creates a TLS config with the CA and client certificates in conf.
+func newTLSConfig(conf TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if strings.TrimSpace(conf.CACertificates) != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(conf.CACertificates)) {
+			return nil, errors.New("cannot find a valid PEM encoded CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.ClientCertificate != "" || conf.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(conf.ClientCertificate), []byte(conf.ClientKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// headerTransport sets headers to requests before sending them with next.
+// headers are set for each request separately, so they're only sent to the redirected hosts
+// that they're allowed for.
+type headerTransport struct {
+	headers []Header
+	// hosts are the hosts that headers without a host are sent to.
+	hosts []string
+	next  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip must not modify the request.
+	req = cloneRequest(req)
+	host := req.URL.Hostname()
+	for _, h := range t.headers {
+		if t.allows(h, host) {
+			req.Header.Set(h.Name, h.Value)
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// allows checks if header h can be sent to host.
+func (t *headerTransport) allows(h Header, host string) bool {
+	if h.Host != "" {
+		return strings.EqualFold(h.Host, host)
+	}
+	for _, allowed := range t.hosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseIdleConnections closes the idle connections of next.
+func (t *headerTransport) CloseIdleConnections() {
+	CloseIdleConnections(t.next)
+}
+
+// CloseIdleConnections closes the idle connections of transport when it keeps any, e.g. once
+// transport is replaced with a new one.
+func CloseIdleConnections(transport http.RoundTripper) {
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// cloneRequest makes a shallow copy of req with a deep copy of its headers.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// ParseHeaders parses headers from s with a header per line in the form of
+// "[<host>] <name>: <value>".
+func ParseHeaders(s string) ([]Header, error) {
+	var headers []Header
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid header %q, it should be in the form of [<host>] <name>: <value>", line)
+		}
+		var header Header
+		if len(fields) == 2 {
+			header.Host = fields[0]
+		}
+		header.Name = fields[len(fields)-1]
+		header.Value = strings.TrimSpace(parts[1])
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
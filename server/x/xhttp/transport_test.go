@@ -0,0 +1,143 @@
+package xhttp
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-Team")))
+	}))
+	defer ts.Close()
+
+	headers, err := ParseHeaders("127.0.0.1 Authorization: Bearer secret\n\nX-Team: ops\nexample.com X-Team: other\n")
+	require.NoError(t, err)
+	transport, err := NewTransport(TransportConfig{Headers: headers, HeaderHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "Bearer secret|ops", readBody(t, resp))
+	require.Empty(t, req.Header, "the request should not be modified")
+
+	// headers without a host are not sent to other hosts.
+	transport, err = NewTransport(TransportConfig{Headers: headers, HeaderHosts: []string{"marketplace.example.com"}})
+	require.NoError(t, err)
+	resp, err = (&http.Client{Transport: transport}).Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "Bearer secret|", readBody(t, resp))
+	CloseIdleConnections(transport)
+
+	_, err = ParseHeaders("Authorization Bearer secret")
+	require.Error(t, err)
+	_, err = ParseHeaders("a b c: d")
+	require.Error(t, err)
+}
+
+func TestTransportHeadersRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	other := "http://localhost:" + tsURL.Port() + "/"
+
+	headers, err := ParseHeaders("Authorization: Bearer secret")
+	require.NoError(t, err)
+	transport, err := NewTransport(TransportConfig{Headers: headers, HeaderHosts: []string{"127.0.0.1"}})
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(ts.URL + "/redirect?to=" + url.QueryEscape(ts.URL+"/"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "Bearer secret", readBody(t, resp))
+
+	// headers are stripped when requests are redirected to other hosts.
+	resp, err = client.Get(ts.URL + "/redirect?to=" + url.QueryEscape(other))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Empty(t, readBody(t, resp))
+}
+
+func TestTransportTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.Organization[0]))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// the test server's certificate is used both as the CA and the client certificate.
+	cert := ts.TLS.Certificates[0]
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey)),
+	})
+
+	// the server's CA is not trusted.
+	transport, err := NewTransport(TransportConfig{})
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(ts.URL)
+	require.Error(t, err)
+
+	transport, err = NewTransport(TransportConfig{
+		CACertificates:    string(certPEM),
+		ClientCertificate: string(certPEM),
+		ClientKey:         string(keyPEM),
+	})
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get(ts.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "Acme Co", readBody(t, resp))
+
+	_, err = NewTransport(TransportConfig{CACertificates: "not a certificate"})
+	require.Error(t, err)
+	_, err = NewTransport(TransportConfig{ClientCertificate: string(certPEM)})
+	require.Error(t, err)
+}
+
+func TestTransportProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	transport, err := NewTransport(TransportConfig{ProxyURL: proxy.URL})
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: transport}).Get("http://marketplace.example.com/api/v1/plugins")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "proxied http://marketplace.example.com/api/v1/plugins", readBody(t, resp))
+
+	_, err = NewTransport(TransportConfig{ProxyURL: ":invalid"})
+	require.Error(t, err)
+}
+
+// readBody reads the body of resp.
+func readBody(t *testing.T, resp *http.Response) string {
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(data)
+}
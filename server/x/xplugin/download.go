@@ -0,0 +1,398 @@
+package xplugin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxBundleSize is the default max size of a plugin bundle in bytes.
+	DefaultMaxBundleSize = 100 << 20
+
+	// manifestName is the name of the manifest file in a plugin bundle.
+	manifestName = "plugin.json"
+)
+
+// bundleContentTypes are the content types that a plugin bundle can be served with.
+// an empty content type is also accepted since some servers don't set it.
+var bundleContentTypes = []string{
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-gtar",
+	"application/x-tar",
+	"application/x-compressed-tar",
+	"application/octet-stream",
+}
+
+// StatusError is returned when a bundle cannot be downloaded because of an unexpected
+// HTTP status code.
+type StatusError struct {
+	// URL of the bundle.
+	URL string
+
+	// StatusCode of the response.
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d while downloading %q", e.StatusCode, e.URL)
+}
+
+// Retryable reports if downloading the bundle might succeed on retry.
+// server errors and rate limits are retryable but other client errors are not.
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// InvalidBundleError is returned when a downloaded file is not a valid plugin bundle.
+type InvalidBundleError struct {
+	// URL of the bundle.
+	URL string
+
+	// Reason explains why the bundle is invalid.
+	Reason string
+}
+
+func (e *InvalidBundleError) Error() string {
+	return fmt.Sprintf("invalid plugin bundle at %q: %s", e.URL, e.Reason)
+}
+
+// Retryable reports that downloading an invalid bundle again will not make it valid.
+func (e *InvalidBundleError) Retryable() bool {
+	return false
+}
+
+// Bundle is a downloaded and validated plugin bundle that is kept in a temporary file.
+// it should be closed to remove the file once it is not needed anymore.
+type Bundle struct {
+	// Manifest of the plugin in the bundle.
+	Manifest *model.Manifest
+
+	// Size of the bundle in bytes.
+	Size int64
+
+	file *os.File
+}
+
+// Reader returns a reader for the bundle from its beginning.
+// only one reader should be used at a time.
+func (b *Bundle) Reader() (io.Reader, error) {
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+// Close removes the bundle's temporary file.
+func (b *Bundle) Close() error {
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// Downloader downloads plugin bundles.
+type Downloader struct {
+	client    *http.Client
+	maxSize   int64
+	progress  func(read, total int64)
+	localDirs []string
+}
+
+// DownloaderOption used to configure a Downloader.
+type DownloaderOption func(*Downloader)
+
+// MaxBundleSizeOption sets the max size of a plugin bundle in bytes.
+func MaxBundleSizeOption(size int64) DownloaderOption {
+	return func(d *Downloader) {
+		if size > 0 {
+			d.maxSize = size
+		}
+	}
+}
+
+// HTTPClientOption sets the HTTP client used to download bundles.
+func HTTPClientOption(client *http.Client) DownloaderOption {
+	return func(d *Downloader) {
+		if client != nil {
+			d.client = client
+		}
+	}
+}
+
+// ProgressOption sets a func that is called with the number of bytes read so far while a bundle is
+// downloaded. total is the size of the bundle or -1 when it is unknown.
+func ProgressOption(progress func(read, total int64)) DownloaderOption {
+	return func(d *Downloader) {
+		d.progress = progress
+	}
+}
+
+// LocalDirsOption allows downloading the files in dirs with file:// URLs.
+// file:// URLs are rejected by default, so a Marketplace cannot make the downloader read
+// arbitrary local files.
+func LocalDirsOption(dirs ...string) DownloaderOption {
+	return func(d *Downloader) {
+		d.localDirs = append(d.localDirs, dirs...)
+	}
+}
+
+// NewDownloader creates a new Downloader with options.
+func NewDownloader(options ...DownloaderOption) *Downloader {
+	d := &Downloader{
+		client:  http.DefaultClient,
+		maxSize: DefaultMaxBundleSize,
+	}
+	for _, o := range options {
+		o(d)
+	}
+	return d
+}
+
+// Download streams the plugin bundle at downloadURL to a temporary file and validates it.
+// the bundle should be a gzipped tar that contains a plugin.json with pluginID.
+// the download is cancelled when ctx is done.
+func (d *Downloader) Download(ctx context.Context, downloadURL, pluginID string) (*Bundle, error) {
+	response, err := d.get(ctx, downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if !isBundleContentType(response.Header.Get("Content-Type")) {
+		return nil, &InvalidBundleError{
+			URL:    downloadURL,
+			Reason: fmt.Sprintf("unexpected content type %q", response.Header.Get("Content-Type")),
+		}
+	}
+	file, err := ioutil.TempFile("", "marketplace-addon-bundle-")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create a temporary file for the plugin")
+	}
+	bundle := &Bundle{file: file}
+	var body io.Reader = response.Body
+	if d.progress != nil {
+		body = &progressReader{r: body, total: response.ContentLength, progress: d.progress}
+	}
+	if bundle.Size, err = d.copy(file, body, response.ContentLength, downloadURL); err != nil {
+		bundle.Close()
+		return nil, err
+	}
+	r, err := bundle.Reader()
+	if err != nil {
+		bundle.Close()
+		return nil, err
+	}
+	if bundle.Manifest, err = ReadManifest(r); err != nil {
+		bundle.Close()
+		return nil, &InvalidBundleError{URL: downloadURL, Reason: err.Error()}
+	}
+	if bundle.Manifest.Id != pluginID {
+		bundle.Close()
+		return nil, &InvalidBundleError{
+			URL:    downloadURL,
+			Reason: fmt.Sprintf("expected %q plugin but got %q", pluginID, bundle.Manifest.Id),
+		}
+	}
+	return bundle, nil
+}
+
+// Fetch downloads a small file like a signature that is published next to a bundle.
+// the file cannot be larger than the max bundle size.
+func (d *Downloader) Fetch(ctx context.Context, fileURL string) ([]byte, error) {
+	response, err := d.get(ctx, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var buf bytes.Buffer
+	if _, err := d.copy(&buf, response.Body, response.ContentLength, fileURL); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// get requests url and checks the status code of the response.
+// file:// URLs are read from the local directories.
+func (d *Downloader) get(ctx context.Context, url string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the download request")
+	}
+	if request.URL.Scheme == "file" {
+		return d.open(url, FileURLPath(request.URL))
+	}
+	response, err := d.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to download the plugin")
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, &StatusError{URL: url, StatusCode: response.StatusCode}
+	}
+	return response, nil
+}
+
+// open opens the file at path downloaded from url as a response if it is in one of the
+// local directories. a missing file is reported with a 404 *StatusError like remote files.
+func (d *Downloader) open(url, path string) (*http.Response, error) {
+	if !d.isInLocalDirs(path) {
+		return nil, fmt.Errorf("%q is not in an allowed local directory", url)
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open the plugin")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "unable to open the plugin")
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, &StatusError{URL: url, StatusCode: http.StatusNotFound}
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header),
+		Body:          file,
+		ContentLength: info.Size(),
+	}, nil
+}
+
+// isInLocalDirs checks if path is inside one of the local directories.
+func (d *Downloader) isInLocalDirs(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range d.localDirs {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// FileURLPath returns the local path of a file:// URL u. the leading slash of windows paths
+// like /C:/plugins is removed.
+func FileURLPath(u *url.URL) string {
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// FileURL returns the file:// URL of the local path.
+func FileURL(path string) string {
+	p := filepath.ToSlash(path)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// copy copies body with contentLength to w up to the max bundle size.
+func (d *Downloader) copy(w io.Writer, body io.Reader, contentLength int64, url string) (int64, error) {
+	tooLarge := &InvalidBundleError{
+		URL:    url,
+		Reason: fmt.Sprintf("larger than the max size of %d bytes", d.maxSize),
+	}
+	if contentLength > d.maxSize {
+		return 0, tooLarge
+	}
+	n, err := io.Copy(w, io.LimitReader(body, d.maxSize+1))
+	if err != nil {
+		return n, errors.Wrap(err, "unable to download the plugin")
+	}
+	if n > d.maxSize {
+		return n, tooLarge
+	}
+	return n, nil
+}
+
+// progressReader reports the progress of reading r.
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	progress func(read, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		r.progress(r.read, r.total)
+	}
+	return n, err
+}
+
+// isBundleContentType checks if contentType is allowed for a plugin bundle.
+func isBundleContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range bundleContentTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadManifest reads the plugin.json of the gzipped tar bundle from r.
+// the manifest can be placed at the root or under a top-level directory.
+func ReadManifest(r io.Reader) (*model.Manifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "not a gzip file")
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s found", manifestName)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "not a tar file")
+		}
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		if !header.FileInfo().Mode().IsRegular() || path.Base(name) != manifestName || strings.Count(name, "/") > 1 {
+			continue
+		}
+		var manifest model.Manifest
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", manifestName)
+		}
+		return &manifest, nil
+	}
+}
@@ -0,0 +1,221 @@
+package xplugin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownload(t *testing.T) {
+	bundle := buildBundle(t, map[string]string{
+		"topdf/":            "",
+		"topdf/plugin.json": `{"id": "topdf", "version": "1.3.0"}`,
+	})
+	notTar := gzipData(t, []byte("not a tar"))
+	noManifest := buildBundle(t, map[string]string{"topdf/README.md": "# topdf"})
+	nestedManifest := buildBundle(t, map[string]string{"topdf/assets/plugin.json": `{"id": "topdf"}`})
+	otherPlugin := buildBundle(t, map[string]string{"plugin.json": `{"id": "jira"}`})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(bundle)
+		case "/octet-stream":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(bundle)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>Not Found</html>"))
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/chunked":
+			// flushing forces a chunked response without a content length.
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(bundle[:10])
+			w.(http.Flusher).Flush()
+			w.Write(bundle[10:])
+		case "/not-gzip":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write([]byte("not a gzip"))
+		case "/not-tar":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(notTar)
+		case "/no-manifest":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(noManifest)
+		case "/nested-manifest":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(nestedManifest)
+		case "/other-plugin":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(otherPlugin)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	t.Run("valid bundle", func(t *testing.T) {
+		for _, p := range []string{"/bundle", "/octet-stream", "/chunked"} {
+			downloaded, err := NewDownloader().Download(context.Background(), ts.URL+p, "topdf")
+			require.NoError(t, err)
+			require.Equal(t, "topdf", downloaded.Manifest.Id)
+			require.Equal(t, "1.3.0", downloaded.Manifest.Version)
+			require.Equal(t, int64(len(bundle)), downloaded.Size)
+
+			r, err := downloaded.Reader()
+			require.NoError(t, err)
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, bundle, data)
+
+			name := downloaded.file.Name()
+			require.NoError(t, downloaded.Close())
+			_, err = os.Stat(name)
+			require.True(t, os.IsNotExist(err))
+		}
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		var read, total int64
+		downloaded, err := NewDownloader(ProgressOption(func(r, t int64) {
+			read, total = r, t
+		})).Download(context.Background(), ts.URL+"/bundle", "topdf")
+		require.NoError(t, err)
+		defer downloaded.Close()
+		require.Equal(t, int64(len(bundle)), read)
+		require.Equal(t, int64(len(bundle)), total)
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		_, err := NewDownloader().Download(context.Background(), ts.URL+"/missing", "topdf")
+		require.Equal(t, &StatusError{URL: ts.URL + "/missing", StatusCode: http.StatusNotFound}, err)
+		require.False(t, err.(*StatusError).Retryable())
+
+		_, err = NewDownloader().Download(context.Background(), ts.URL+"/unavailable", "topdf")
+		require.IsType(t, &StatusError{}, err)
+		require.True(t, err.(*StatusError).Retryable())
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		tests := []struct {
+			path    string
+			options []DownloaderOption
+		}{
+			{"/html", nil},
+			{"/bundle", []DownloaderOption{MaxBundleSizeOption(int64(len(bundle) - 1))}},
+			{"/chunked", []DownloaderOption{MaxBundleSizeOption(int64(len(bundle) - 1))}},
+			{"/not-gzip", nil},
+			{"/not-tar", nil},
+			{"/no-manifest", nil},
+			{"/nested-manifest", nil},
+			{"/other-plugin", nil},
+		}
+		for _, tt := range tests {
+			_, err := NewDownloader(tt.options...).Download(context.Background(), ts.URL+tt.path, "topdf")
+			require.IsType(t, &InvalidBundleError{}, err, tt.path)
+			require.False(t, err.(*InvalidBundleError).Retryable())
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		data, err := NewDownloader().Fetch(context.Background(), ts.URL+"/html")
+		require.NoError(t, err)
+		require.Equal(t, "<html>Not Found</html>", string(data))
+
+		_, err = NewDownloader(MaxBundleSizeOption(5)).Fetch(context.Background(), ts.URL+"/html")
+		require.IsType(t, &InvalidBundleError{}, err)
+	})
+}
+
+func TestDownloadLocalFile(t *testing.T) {
+	bundle := buildBundle(t, map[string]string{"plugin.json": `{"id": "topdf", "version": "1.3.0"}`})
+	dir, err := ioutil.TempDir("", "marketplace-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "other-")
+	require.NoError(t, err)
+	defer os.RemoveAll(other)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "topdf.tgz"), bundle, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(other, "topdf.tgz"), bundle, 0644))
+
+	downloader := NewDownloader(LocalDirsOption(dir))
+	downloaded, err := downloader.Download(context.Background(), FileURL(filepath.Join(dir, "topdf.tgz")), "topdf")
+	require.NoError(t, err)
+	require.Equal(t, "1.3.0", downloaded.Manifest.Version)
+	require.NoError(t, downloaded.Close())
+
+	// missing files are reported like missing remote files.
+	_, err = downloader.Fetch(context.Background(), FileURL(filepath.Join(dir, "topdf.tgz.sig")))
+	require.Equal(t, &StatusError{URL: FileURL(filepath.Join(dir, "topdf.tgz.sig")), StatusCode: http.StatusNotFound}, errors.Cause(err))
+
+	// files outside of the local dirs cannot be read.
+	for _, fileURL := range []string{
+		FileURL(filepath.Join(other, "topdf.tgz")),
+		FileURL(dir) + "/../" + filepath.Base(other) + "/topdf.tgz",
+		FileURL(dir),
+	} {
+		_, err = downloader.Fetch(context.Background(), fileURL)
+		require.Error(t, err, fileURL)
+		require.NotEqual(t, http.StatusNotFound, statusCode(err), fileURL)
+	}
+	_, err = NewDownloader().Fetch(context.Background(), FileURL(filepath.Join(dir, "topdf.tgz")))
+	require.Error(t, err)
+}
+
+func TestFileURL(t *testing.T) {
+	u, err := url.Parse("file:///C:/plugins/topdf.tgz")
+	require.NoError(t, err)
+	require.Equal(t, filepath.FromSlash("C:/plugins/topdf.tgz"), FileURLPath(u))
+	u, err = url.Parse(FileURL("/var/plugins/topdf.tgz"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.FromSlash("/var/plugins/topdf.tgz"), FileURLPath(u))
+}
+
+// statusCode returns the status code of err if it is a *StatusError.
+func statusCode(err error) int {
+	if serr, ok := errors.Cause(err).(*StatusError); ok {
+		return serr.StatusCode
+	}
+	return 0
+}
+
+// buildBundle creates a gzipped tar from files by their names and contents.
+// names ending with a slash are added as directories.
+func buildBundle(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			header.Mode, header.Typeflag = 0755, tar.TypeDir
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return gzipData(t, buf.Bytes())
+}
+
+// gzipData compresses data with gzip.
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
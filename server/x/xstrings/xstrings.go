@@ -1,5 +1,7 @@
 package xstrings
 
+import "strings"
+
 // SliceContains checks if e is a member of a.
 func SliceContains(a []string, e string) bool {
 	for _, s := range a {
@@ -9,3 +11,14 @@ func SliceContains(a []string, e string) bool {
 	}
 	return false
 }
+
+// SplitList splits a comma separated list s into its trimmed, non-empty members.
+func SplitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
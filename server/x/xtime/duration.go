@@ -4,9 +4,15 @@ package xtime
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// days matches the durations in days, e.g. "3d" or "1.5d".
+var days = regexp.MustCompile(`[0-9]*\.?[0-9]+d`)
+
 // Duration is a time.Duration with JSON decoding support.
 type Duration time.Duration
 
@@ -17,7 +23,7 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 		return err
 	}
 	if value, ok := v.(string); ok {
-		dr, err := time.ParseDuration(value)
+		dr, err := ParseDuration(value)
 		if err != nil {
 			return err
 		}
@@ -26,3 +32,20 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 	}
 	return fmt.Errorf("invalid duration %q", b)
 }
+
+// ParseDuration parses a duration from s like time.ParseDuration does but it also accepts days
+// with the "d" unit, e.g. "3d" or "1d12h". an empty s is parsed as zero.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	hours := days.ReplaceAllStringFunc(s, func(d string) string {
+		n, _ := strconv.ParseFloat(strings.TrimSuffix(d, "d"), 64)
+		return strconv.FormatFloat(n*24, 'f', -1, 64) + "h"
+	})
+	dr, err := time.ParseDuration(hours)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return dr, nil
+}
@@ -2,6 +2,7 @@ package xtime
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -14,4 +15,29 @@ func TestDurationUnmarshal(t *testing.T) {
 	}
 	require.NoError(t, json.Unmarshal([]byte(`{"t":"10s"}`), &data))
 	require.Equal(t, time.Second*10, time.Duration(data.T))
+	require.NoError(t, json.Unmarshal([]byte(`{"t":"7d"}`), &data))
+	require.Equal(t, 7*24*time.Hour, time.Duration(data.T))
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s  string
+		dr time.Duration
+	}{
+		{"", 0},
+		{"72h", 72 * time.Hour},
+		{"3d", 72 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"1d12h30m", 36*time.Hour + 30*time.Minute},
+	}
+	for _, tt := range tests {
+		dr, err := ParseDuration(tt.s)
+		require.NoError(t, err, tt.s)
+		require.Equal(t, tt.dr, dr, tt.s)
+	}
+
+	for _, s := range []string{"7", "d", "3 days"} {
+		_, err := ParseDuration(s)
+		require.Equal(t, fmt.Sprintf("invalid duration %q", s), err.Error())
+	}
 }
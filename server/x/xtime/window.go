@@ -0,0 +1,168 @@
+package xtime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays maps short weekday names to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a weekly recurring time window like "Sat-Sun 02:00-05:00 Europe/Berlin".
+// a window that ends before it starts, like "Fri 22:00-02:00", ends on the next day.
+type Window struct {
+	// days keeps the weekdays that the window opens on.
+	days [7]bool
+
+	// start and end are the opening and closing times since midnight.
+	start, end time.Duration
+
+	// location is the time zone of the window.
+	location *time.Location
+
+	// raw is the window as it is parsed.
+	raw string
+}
+
+// ParseWindow parses a window from s in the form of "<days> <HH:MM>-<HH:MM> [time zone]".
+// days are a comma separated list of weekdays or weekday ranges like "Mon,Wed" or "Sat-Sun".
+// time zone is an IANA time zone name and defaults to UTC.
+func ParseWindow(s string) (Window, error) {
+	w := Window{location: time.UTC, raw: strings.TrimSpace(s)}
+	fields := strings.Fields(s)
+	if len(fields) != 2 && len(fields) != 3 {
+		return w, fmt.Errorf("invalid window %q, it should be in the form of <days> <HH:MM>-<HH:MM> [time zone]", s)
+	}
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, err
+	}
+	if err := w.parseTimes(fields[1]); err != nil {
+		return w, err
+	}
+	if len(fields) == 3 {
+		location, err := time.LoadLocation(fields[2])
+		if err != nil {
+			return w, fmt.Errorf("invalid time zone %q in window %q", fields[2], s)
+		}
+		w.location = location
+	}
+	return w, nil
+}
+
+// ParseWindows parses a semicolon separated list of windows from s.
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for _, ws := range strings.Split(s, ";") {
+		if strings.TrimSpace(ws) == "" {
+			continue
+		}
+		w, err := ParseWindow(ws)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseDays parses weekdays of the window from s.
+func (w *Window) parseDays(s string) error {
+	for _, days := range strings.Split(s, ",") {
+		bounds := strings.Split(days, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid days %q", days)
+		}
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("invalid day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimes parses the opening and closing times of the window from s.
+func (w *Window) parseTimes(s string) error {
+	bounds := strings.Split(s, "-")
+	if len(bounds) != 2 {
+		return fmt.Errorf("invalid time range %q", s)
+	}
+	var err error
+	if w.start, err = parseClock(bounds[0]); err != nil {
+		return err
+	}
+	if w.end, err = parseClock(bounds[1]); err != nil {
+		return err
+	}
+	if w.start == w.end {
+		return fmt.Errorf("empty time range %q", s)
+	}
+	return nil
+}
+
+// parseClock parses s in the form of HH:MM as a duration since midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, it should be in the form of HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains checks if t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	sinceMidnight := t.Sub(midnight(t, 0))
+	if w.start < w.end {
+		return w.days[t.Weekday()] && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	// the window ends on the next day.
+	yesterday := (t.Weekday() + 6) % 7
+	return (w.days[t.Weekday()] && sinceMidnight >= w.start) || (w.days[yesterday] && sinceMidnight < w.end)
+}
+
+// Next returns t if it is inside the window, otherwise the next opening time of the window after t.
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	local := t.In(w.location)
+	for day := 0; day <= 7; day++ {
+		opening := midnight(local, day).Add(w.start)
+		if w.days[opening.Weekday()] && opening.After(t) {
+			return opening
+		}
+	}
+	// unreachable for a parsed window since it opens at least once a week.
+	return time.Time{}
+}
+
+// String returns the window as it is parsed.
+func (w Window) String() string {
+	return w.raw
+}
+
+// midnight returns the midnight of days after t's day in t's location.
+func midnight(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, t.Location())
+}
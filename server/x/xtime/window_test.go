@@ -0,0 +1,62 @@
+package xtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindow(t *testing.T) {
+	w, err := ParseWindow("Sat-Sun 02:00-05:00 Europe/Berlin")
+	require.NoError(t, err)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// 2019-11-16 is a Saturday.
+	require.True(t, w.Contains(time.Date(2019, 11, 16, 2, 0, 0, 0, berlin)))
+	require.True(t, w.Contains(time.Date(2019, 11, 17, 4, 59, 0, 0, berlin)))
+	require.True(t, w.Contains(time.Date(2019, 11, 16, 1, 30, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2019, 11, 16, 5, 0, 0, 0, berlin)))
+	require.False(t, w.Contains(time.Date(2019, 11, 15, 3, 0, 0, 0, berlin)))
+
+	friday := time.Date(2019, 11, 15, 12, 0, 0, 0, berlin)
+	require.True(t, time.Date(2019, 11, 16, 2, 0, 0, 0, berlin).Equal(w.Next(friday)))
+	sunday := time.Date(2019, 11, 17, 6, 0, 0, 0, berlin)
+	require.True(t, time.Date(2019, 11, 23, 2, 0, 0, 0, berlin).Equal(w.Next(sunday)))
+	inside := time.Date(2019, 11, 17, 3, 0, 0, 0, berlin)
+	require.Equal(t, inside, w.Next(inside))
+	require.Equal(t, "Sat-Sun 02:00-05:00 Europe/Berlin", w.String())
+}
+
+func TestWindowOvernight(t *testing.T) {
+	w, err := ParseWindow("Fri,Sun 22:00-02:00")
+	require.NoError(t, err)
+	require.True(t, w.Contains(time.Date(2019, 11, 15, 23, 0, 0, 0, time.UTC)))
+	require.True(t, w.Contains(time.Date(2019, 11, 16, 1, 0, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2019, 11, 16, 23, 0, 0, 0, time.UTC)))
+	require.True(t, w.Contains(time.Date(2019, 11, 18, 1, 0, 0, 0, time.UTC)))
+	require.False(t, w.Contains(time.Date(2019, 11, 18, 2, 0, 0, 0, time.UTC)))
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("Sat-Sun 02:00-05:00 Europe/Berlin; Wed 22:00-23:00")
+	require.NoError(t, err)
+	require.Len(t, windows, 2)
+
+	windows, err = ParseWindows("")
+	require.NoError(t, err)
+	require.Len(t, windows, 0)
+
+	for _, s := range []string{
+		"Sat",
+		"Someday 02:00-05:00",
+		"Sat 02:00",
+		"Sat 2am-5am",
+		"Sat 02:00-02:00",
+		"Sat 02:00-05:00 Mars/Olympus",
+	} {
+		_, err := ParseWindow(s)
+		require.Error(t, err, s)
+	}
+}